@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"errors"
 	"log"
 	"os"
@@ -12,6 +13,7 @@ import (
 	"gorm.io/driver/sqlite"
 	"gorm.io/driver/sqlserver"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
@@ -37,10 +39,17 @@ type Database interface {
 
 	Open(path string) error
 
+	Ping() error
+
+	PingContext(ctx context.Context) error
+
 	Read(out interface{}, query interface{}, args ...interface{}) error
 
 	ReadAll(out interface{}) error
 
+	ReadPage(out interface{}, page Page, query interface{},
+		args ...interface{}) (int64, error)
+
 	Save(value interface{}) error
 
 	SaveTx(value interface{}) error
@@ -63,6 +72,19 @@ type Logger logger.Writer
 
 type TxFn func(tx *gorm.DB) error
 
+// Page represents a window of ordered records.
+type Page struct {
+	Limit  int
+	Offset int
+	Sort   []Sort
+}
+
+// Sort represents the ordering of records by a column.
+type Sort struct {
+	Column string
+	Desc   bool
+}
+
 type database struct {
 	*gorm.DB
 	dialect            string
@@ -138,6 +160,22 @@ func (db *database) Open(path string) error {
 	return nil
 }
 
+func (db *database) Ping() error {
+	sqlDb, err := db.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDb.Ping()
+}
+
+func (db *database) PingContext(ctx context.Context) error {
+	sqlDb, err := db.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDb.PingContext(ctx)
+}
+
 func (db *database) Read(out interface{}, query interface{},
 	args ...interface{}) error {
 	return db.DB.Where(query, args...).First(out).Error
@@ -147,6 +185,31 @@ func (db *database) ReadAll(out interface{}) error {
 	return db.DB.Find(out).Error
 }
 
+func (db *database) ReadPage(out interface{}, page Page, query interface{},
+	args ...interface{}) (int64, error) {
+	tx := db.DB.Model(out)
+	if query != nil {
+		tx = tx.Where(query, args...)
+	}
+	var total int64
+	if err := tx.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return 0, err
+	}
+	for _, s := range page.Sort {
+		tx = tx.Order(clause.OrderByColumn{
+			Column: clause.Column{Name: s.Column},
+			Desc:   s.Desc,
+		})
+	}
+	if page.Limit > 0 {
+		tx = tx.Limit(page.Limit)
+	}
+	if page.Offset > 0 {
+		tx = tx.Offset(page.Offset)
+	}
+	return total, tx.Find(out).Error
+}
+
 func (db *database) Save(value interface{}) error {
 	return db.DB.Save(value).Error
 }
@@ -12,6 +12,17 @@ type Logger struct {
 	*logrus.Logger
 }
 
+type Fields = logrus.Fields
+
+type Level = logrus.Level
+
+const (
+	ErrorLevel   = logrus.ErrorLevel
+	WarningLevel = logrus.WarnLevel
+	InfoLevel    = logrus.InfoLevel
+	DebugLevel   = logrus.DebugLevel
+)
+
 var log Logger
 var once sync.Once
 var Log = func() Logger {
@@ -36,6 +47,10 @@ func SetFile(path string) error {
 	return nil
 }
 
+func WithFields(fields Fields) *logrus.Entry {
+	return Log.WithFields(fields)
+}
+
 func Debug(args ...interface{}) {
 	Log.Debug(args...)
 }
@@ -0,0 +1,463 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the default histogram buckets; suitable for request
+// latencies in seconds.
+var DefaultBuckets = []float64{
+	.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+// DefaultRegistry is the registry used by the package level functions.
+var DefaultRegistry = NewRegistry()
+
+var (
+	metricNameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegexp  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Metric represents a named metric that can be written in the Prometheus text
+// exposition format.
+type Metric interface {
+	// Name returns the name of the metric.
+	Name() string
+
+	// Write writes the metric's HELP, TYPE and samples to the writer.
+	Write(w io.Writer) error
+}
+
+// Registry represents a set of uniquely named metrics.
+type Registry struct {
+	mu      sync.RWMutex
+	metrics map[string]Metric
+}
+
+// NewRegistry returns a new, empty, registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]Metric{}}
+}
+
+// Register adds the metric to the registry; returning an error if a metric of
+// the same name is already registered.
+func (r *Registry) Register(m Metric) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[m.Name()]; ok {
+		return fmt.Errorf("metric %q is already registered", m.Name())
+	}
+	r.metrics[m.Name()] = m
+	return nil
+}
+
+// GetOrRegister adds the metric to the registry, unless a metric of the same
+// name is already registered; returning the registered metric.
+func (r *Registry) GetOrRegister(m Metric) Metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.metrics[m.Name()]; ok {
+		return existing
+	}
+	r.metrics[m.Name()] = m
+	return m
+}
+
+// MustRegister adds the metrics to the registry; panicking on failure.
+func (r *Registry) MustRegister(metrics ...Metric) {
+	for _, m := range metrics {
+		if err := r.Register(m); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Unregister removes the named metric from the registry.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.metrics, name)
+}
+
+// Write writes all metrics, ordered by name, to the writer in the Prometheus
+// text exposition format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]Metric, len(names))
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.RUnlock()
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		if err := m.Write(bw); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Handler returns an HTTP handler exposing the registry's metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		w.WriteHeader(http.StatusOK)
+		r.Write(w)
+	})
+}
+
+// Register adds the metric to the default registry.
+func Register(m Metric) error {
+	return DefaultRegistry.Register(m)
+}
+
+// MustRegister adds the metrics to the default registry; panicking on
+// failure.
+func MustRegister(metrics ...Metric) {
+	DefaultRegistry.MustRegister(metrics...)
+}
+
+// desc represents the description shared by all metric types.
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+// newDesc returns a new description; panicking if the metric or label names
+// are invalid.
+func newDesc(name, help, kind string, labels []string) desc {
+	if !metricNameRegexp.MatchString(name) {
+		panic(fmt.Sprintf("invalid metric name %q", name))
+	}
+	for _, label := range labels {
+		if !labelNameRegexp.MatchString(label) || label == "le" {
+			panic(fmt.Sprintf("invalid label name %q", label))
+		}
+	}
+	return desc{name: name, help: help, kind: kind, labels: labels}
+}
+
+// Name returns the name of the metric.
+func (d desc) Name() string {
+	return d.name
+}
+
+// key returns the series key of the label values; panicking if the number of
+// values does not match the number of labels.
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf(
+			"metric %q expects %d label values, got %d",
+			d.name, len(d.labels), len(values),
+		))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// writeHeader writes the HELP and TYPE lines of the metric.
+func (d desc) writeHeader(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n",
+		d.name, escapeHelp(d.help), d.name, d.kind)
+	return err
+}
+
+// writeSample writes a sample line of the metric; with any extra label
+// appended to the series' labels.
+func (d desc) writeSample(w io.Writer, suffix string, values []string, extra string, v float64) error {
+	pairs := make([]string, 0, len(values)+1)
+	for i, label := range d.labels {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", label, escapeLabel(values[i])))
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	labels := ""
+	if len(pairs) > 0 {
+		labels = "{" + strings.Join(pairs, ",") + "}"
+	}
+	_, err := fmt.Fprintf(w, "%s%s%s %s\n", d.name, suffix, labels, formatFloat(v))
+	return err
+}
+
+// series represents the value of a single label combination.
+type series struct {
+	values []string
+	value  float64
+}
+
+// Counter represents a monotonically increasing value per label combination.
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// NewCounter returns a new counter with the given label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{
+		desc:   newDesc(name, help, "counter", labels),
+		series: map[string]*series{},
+	}
+}
+
+// Inc increments the counter of the label values by one.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add increments the counter of the label values by the given amount;
+// panicking if it is negative.
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("counter %q cannot decrease", c.name))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	getSeries(c.series, c.desc, values).value += v
+}
+
+// Value returns the counter of the label values.
+func (c *Counter) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.series[c.key(values)]; ok {
+		return s.value
+	}
+	return 0
+}
+
+// Write writes the counter in the Prometheus text exposition format.
+func (c *Counter) Write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return writeSeries(w, c.desc, c.series)
+}
+
+// Gauge represents a value, that can go up and down, per label combination.
+type Gauge struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// NewGauge returns a new gauge with the given label names.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{
+		desc:   newDesc(name, help, "gauge", labels),
+		series: map[string]*series{},
+	}
+}
+
+// Set sets the gauge of the label values.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	getSeries(g.series, g.desc, values).value = v
+}
+
+// Add adds the given amount to the gauge of the label values.
+func (g *Gauge) Add(v float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	getSeries(g.series, g.desc, values).value += v
+}
+
+// Inc increments the gauge of the label values by one.
+func (g *Gauge) Inc(values ...string) {
+	g.Add(1, values...)
+}
+
+// Dec decrements the gauge of the label values by one.
+func (g *Gauge) Dec(values ...string) {
+	g.Add(-1, values...)
+}
+
+// Value returns the gauge of the label values.
+func (g *Gauge) Value(values ...string) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if s, ok := g.series[g.key(values)]; ok {
+		return s.value
+	}
+	return 0
+}
+
+// Write writes the gauge in the Prometheus text exposition format.
+func (g *Gauge) Write(w io.Writer) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return writeSeries(w, g.desc, g.series)
+}
+
+// histogramSeries represents the observations of a single label combination.
+type histogramSeries struct {
+	values []string
+	counts []uint64 // non-cumulative count per bucket
+	count  uint64
+	sum    float64
+}
+
+// Histogram represents the distribution of observations, in configurable
+// buckets, per label combination.
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// NewHistogram returns a new histogram with the given upper bounds and label
+// names. If no buckets are given, DefaultBuckets are used.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	if math.IsInf(buckets[len(buckets)-1], 1) {
+		buckets = buckets[:len(buckets)-1]
+	}
+	return &Histogram{
+		desc:    newDesc(name, help, "histogram", labels),
+		buckets: buckets,
+		series:  map[string]*histogramSeries{},
+	}
+}
+
+// Observe adds the observation to the histogram of the label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			values: append([]string{}, values...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// Count returns the number of observations of the label values.
+func (h *Histogram) Count(values ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[h.key(values)]; ok {
+		return s.count
+	}
+	return 0
+}
+
+// Write writes the histogram in the Prometheus text exposition format.
+func (h *Histogram) Write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.writeHeader(w); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		cumulative := uint64(0)
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			le := fmt.Sprintf("le=\"%s\"", formatFloat(bound))
+			if err := h.writeSample(w, "_bucket", s.values, le, float64(cumulative)); err != nil {
+				return err
+			}
+		}
+		if err := h.writeSample(w, "_bucket", s.values, "le=\"+Inf\"", float64(s.count)); err != nil {
+			return err
+		}
+		if err := h.writeSample(w, "_sum", s.values, "", s.sum); err != nil {
+			return err
+		}
+		if err := h.writeSample(w, "_count", s.values, "", float64(s.count)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getSeries returns the series of the label values; creating it if it does not
+// exist.
+func getSeries(m map[string]*series, d desc, values []string) *series {
+	key := d.key(values)
+	s, ok := m[key]
+	if !ok {
+		s = &series{values: append([]string{}, values...)}
+		m[key] = s
+	}
+	return s
+}
+
+// writeSeries writes the header and samples of a counter or gauge.
+func writeSeries(w io.Writer, d desc, m map[string]*series) error {
+	if err := d.writeHeader(w); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := m[key]
+		if err := d.writeSample(w, "", s.values, "", s.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatFloat formats the value as a Prometheus sample value.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeHelp escapes backslashes and newlines in HELP text.
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// escapeLabel escapes backslashes, quotes and newlines in label values.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
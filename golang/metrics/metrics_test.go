@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("jobs_total", "Total jobs.", "queue")
+	c.Inc("default")
+	c.Add(2, "default")
+	c.Inc("other")
+	require.Equal(t, float64(3), c.Value("default"))
+	require.Equal(t, float64(1), c.Value("other"))
+	require.Equal(t, float64(0), c.Value("missing"))
+	require.Panics(t, func() { c.Add(-1, "default") })
+	require.Panics(t, func() { c.Inc() })
+}
+
+func TestGauge(t *testing.T) {
+	g := NewGauge("workers", "Active workers.")
+	g.Set(5)
+	g.Inc()
+	g.Dec()
+	g.Dec()
+	require.Equal(t, float64(4), g.Value())
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("latency_seconds", "Latency.", []float64{1, 0.1}, "op")
+	h.Observe(0.05, "read")
+	h.Observe(0.5, "read")
+	h.Observe(5, "read")
+	require.Equal(t, uint64(3), h.Count("read"))
+	b := bytes.Buffer{}
+	require.Nil(t, h.Write(&b))
+	expected := "# HELP latency_seconds Latency.\n" +
+		"# TYPE latency_seconds histogram\n" +
+		"latency_seconds_bucket{op=\"read\",le=\"0.1\"} 1\n" +
+		"latency_seconds_bucket{op=\"read\",le=\"1\"} 2\n" +
+		"latency_seconds_bucket{op=\"read\",le=\"+Inf\"} 3\n" +
+		"latency_seconds_sum{op=\"read\"} 5.55\n" +
+		"latency_seconds_count{op=\"read\"} 3\n"
+	require.Equal(t, expected, b.String())
+}
+
+func TestInvalidNames(t *testing.T) {
+	require.Panics(t, func() { NewCounter("bad-name", "") })
+	require.Panics(t, func() { NewCounter("ok", "", "bad-label") })
+	require.Panics(t, func() { NewHistogram("ok", "", nil, "le") })
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounter("b_total", "Line one\nline two.", "path")
+	g := NewGauge("a", "A gauge.")
+	require.Nil(t, r.Register(c))
+	require.Nil(t, r.Register(g))
+	require.NotNil(t, r.Register(NewCounter("b_total", "")))
+	c.Inc(`/say "hi"`)
+	g.Set(1.5)
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/metrics", nil)
+	require.Nil(t, err)
+	r.Handler().ServeHTTP(rr, req)
+	require.Equal(t, ContentType, rr.Header().Get("Content-Type"))
+	expected := "# HELP a A gauge.\n" +
+		"# TYPE a gauge\n" +
+		"a 1.5\n" +
+		"# HELP b_total Line one\\nline two.\n" +
+		"# TYPE b_total counter\n" +
+		"b_total{path=\"/say \\\"hi\\\"\"} 1\n"
+	require.Equal(t, expected, rr.Body.String())
+
+	r.Unregister("a")
+	require.Nil(t, r.Register(NewGauge("a", "")))
+
+	require.Same(t, c, r.GetOrRegister(NewCounter("b_total", "")))
+	d := NewCounter("d_total", "")
+	require.Same(t, d, r.GetOrRegister(d))
+}
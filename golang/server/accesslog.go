@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/crossedbot/common/golang/logger"
+)
+
+// AccessLogOptions represents the configuration of the server's access log.
+type AccessLogOptions struct {
+	// Level is the level entries are logged at; defaults to info.
+	Level logger.Level
+
+	// SuccessSampleRate is the fraction, between 0 and 1, of successful
+	// (2xx) responses that are logged. A zero value logs all responses.
+	// Responses with any other status are always logged.
+	SuccessSampleRate float64
+
+	// Skip is a list of request paths or route patterns, e.g. health
+	// endpoints, that are never logged.
+	Skip []string
+}
+
+// routeRecorder records the pattern of the route that handled a request, for
+// handlers that run before the request is routed.
+type routeRecorder struct {
+	pattern string
+}
+
+// recordRoute records the route pattern in the request's route recorder, if
+// it has one.
+func recordRoute(ctx context.Context, pattern string) {
+	if rec, ok := ctx.Value(routeRecorderKey).(*routeRecorder); ok {
+		rec.pattern = pattern
+	}
+}
+
+// accessLog returns a handler that logs a structured entry for each request
+// once it has been handled by the next handler. Unrouted requests, e.g. those
+// answered with a 404 or 405, are logged with an empty route.
+func accessLog(opts AccessLogOptions, next http.Handler) http.Handler {
+	level := opts.Level
+	if level < logger.ErrorLevel {
+		level = logger.InfoLevel
+	}
+	skip := make(map[string]struct{}, len(opts.Skip))
+	for _, p := range opts.Skip {
+		skip[cleanPath(p)] = struct{}{}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := skip[cleanPath(r.URL.Path)]; ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &routeRecorder{}
+		r = r.WithContext(context.WithValue(r.Context(), routeRecorderKey, rec))
+		start := time.Now()
+		rw := newResponseWriter(w)
+		next.ServeHTTP(rw, r)
+		latency := time.Since(start)
+		if _, ok := skip[rec.pattern]; ok {
+			return
+		}
+		if !sampled(rw.Status(), opts.SuccessSampleRate) {
+			return
+		}
+		logger.WithFields(logger.Fields{
+			"method":      r.Method,
+			"route":       rec.pattern,
+			"path":        r.URL.Path,
+			"status":      rw.Status(),
+			"bytes":       rw.Size(),
+			"latency_ms":  float64(latency) / float64(time.Millisecond),
+			"remote_addr": r.RemoteAddr,
+			"request_id":  GetRequestId(r.Context()),
+			"user_agent":  r.UserAgent(),
+		}).Log(level, "request")
+	})
+}
+
+// sampled returns true if a response with the given status should be logged
+// for the given success sample rate.
+func sampled(status int, rate float64) bool {
+	if status < 200 || status >= 300 || rate <= 0 || rate >= 1 {
+		return true
+	}
+	return rand.Float64() < rate
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/crossedbot/common/golang/logger"
+)
+
+// captureLog redirects the logger's output to a buffer for the duration of
+// the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	out, formatter := logger.Log.Out, logger.Log.Formatter
+	logger.Log.Out = buf
+	logger.Log.Formatter = &logrus.JSONFormatter{}
+	t.Cleanup(func() {
+		logger.Log.Out = out
+		logger.Log.Formatter = formatter
+	})
+	return buf
+}
+
+func TestAccessLog(t *testing.T) {
+	buf := captureLog(t)
+	s := New(":8080", 10, 10,
+		WithHealth(HealthOptions{}),
+		WithAccessLog(AccessLogOptions{Skip: []string{DefaultLivenessPath}}),
+	).(*server)
+	s.run = 1
+	handler := func(w http.ResponseWriter, r *http.Request, p Parameters) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	}
+	require.Nil(t, s.Add(handler, http.MethodPost, "/users/:id"))
+
+	entries := func() []map[string]interface{} {
+		entries := []map[string]interface{}{}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			entry := map[string]interface{}{}
+			require.Nil(t, json.Unmarshal([]byte(line), &entry))
+			entries = append(entries, entry)
+		}
+		buf.Reset()
+		return entries
+	}
+
+	r, err := http.NewRequest(http.MethodPost, "/users/abc", nil)
+	require.Nil(t, err)
+	r.Header.Set(RequestIdHeader, "abc123")
+	r.Header.Set("User-Agent", "test")
+	s.handler().ServeHTTP(httptest.NewRecorder(), r)
+	logged := entries()
+	require.Equal(t, 1, len(logged))
+	require.Equal(t, "info", logged[0]["level"])
+	require.Equal(t, http.MethodPost, logged[0]["method"])
+	require.Equal(t, "/users/:id", logged[0]["route"])
+	require.Equal(t, "/users/abc", logged[0]["path"])
+	require.Equal(t, float64(http.StatusCreated), logged[0]["status"])
+	require.Equal(t, float64(5), logged[0]["bytes"])
+	require.Equal(t, "abc123", logged[0]["request_id"])
+	require.Equal(t, "test", logged[0]["user_agent"])
+
+	// Skipped paths are not logged.
+	r, err = http.NewRequest(http.MethodGet, DefaultLivenessPath, nil)
+	require.Nil(t, err)
+	s.handler().ServeHTTP(httptest.NewRecorder(), r)
+	require.Equal(t, 0, len(entries()))
+
+	// Endpoints registered directly on the router are logged.
+	r, err = http.NewRequest(http.MethodGet, DefaultReadinessPath, nil)
+	require.Nil(t, err)
+	s.handler().ServeHTTP(httptest.NewRecorder(), r)
+	logged = entries()
+	require.Equal(t, 1, len(logged))
+	require.Equal(t, float64(http.StatusOK), logged[0]["status"])
+
+	// Unrouted requests are logged without a route.
+	for _, tc := range []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/missing", http.StatusNotFound},
+		{http.MethodDelete, "/users/abc", http.StatusMethodNotAllowed},
+	} {
+		r, err = http.NewRequest(tc.method, tc.path, nil)
+		require.Nil(t, err)
+		s.handler().ServeHTTP(httptest.NewRecorder(), r)
+		logged = entries()
+		require.Equal(t, 1, len(logged))
+		require.Equal(t, "", logged[0]["route"])
+		require.Equal(t, float64(tc.status), logged[0]["status"])
+		require.NotEmpty(t, logged[0]["request_id"])
+	}
+
+	// Requests refused while the server is stopping are logged.
+	s.run = 0
+	r, err = http.NewRequest(http.MethodPost, "/users/abc", nil)
+	require.Nil(t, err)
+	s.handler().ServeHTTP(httptest.NewRecorder(), r)
+	logged = entries()
+	require.Equal(t, 1, len(logged))
+	require.Equal(t, "/users/:id", logged[0]["route"])
+	require.Equal(t, float64(http.StatusServiceUnavailable), logged[0]["status"])
+}
+
+func TestSampled(t *testing.T) {
+	require.True(t, sampled(http.StatusOK, 0))
+	require.True(t, sampled(http.StatusOK, 1))
+	require.True(t, sampled(http.StatusInternalServerError, 0.0001))
+	require.False(t, sampled(http.StatusOK, 0.0000000001))
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/crossedbot/common/golang/crypto"
+	"github.com/crossedbot/common/golang/db"
+	"github.com/crossedbot/common/golang/logger"
+)
+
+const (
+	// ApiKeyHeader is the header carrying an API key.
+	ApiKeyHeader = "X-API-Key"
+
+	// ApiKeyScheme is the Authorization header scheme carrying an API key.
+	ApiKeyScheme = "ApiKey"
+
+	// ApiKeyPrefixLength is the length of an API key's prefix.
+	ApiKeyPrefixLength = 8
+
+	// ApiKeySecretLength is the length of an API key's secret.
+	ApiKeySecretLength = 32
+)
+
+var (
+	ErrApiKeyInvalid  = errors.New("API key is invalid")
+	ErrApiKeyRevoked  = errors.New("API key is revoked")
+	ErrApiKeyExpired  = errors.New("API key is expired")
+	ErrApiKeyNotFound = errors.New("API key not found")
+)
+
+// ApiKey represents an issued API key. Only a hash of the key is stored; the
+// key's prefix identifies it.
+type ApiKey struct {
+	Id        uint       `gorm:"primaryKey" json:"-"`
+	Prefix    string     `gorm:"uniqueIndex;size:16" json:"prefix"`
+	Hash      string     `gorm:"size:64" json:"-"`
+	Name      string     `json:"name"`
+	Scopes    string     `json:"scopes"` // space separated
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// TableName returns the name of the table holding API keys.
+func (ApiKey) TableName() string {
+	return "api_keys"
+}
+
+// ScopeList returns the key's scopes as a list.
+func (k ApiKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+// HasScope returns true if the key has been granted the scope.
+func (k ApiKey) HasScope(scope string) bool {
+	return containsAny(k.ScopeList(), scope)
+}
+
+// GetApiKey returns the authenticated API key stored in the context. If the
+// request was not authenticated by an API key, nil is returned.
+func GetApiKey(ctx context.Context) *ApiKey {
+	key, _ := ctx.Value(apiKeyKey).(*ApiKey)
+	return key
+}
+
+// ApiKeyStore represents a store of API keys.
+type ApiKeyStore interface {
+	// Create issues a new API key with the given name, scopes and
+	// expiration; a zero expiration never expires. The key is returned in
+	// plain text only once.
+	Create(name string, scopes []string, expiresAt time.Time) (string, ApiKey, error)
+
+	// Authenticate returns the stored API key matching the given key.
+	Authenticate(key string) (ApiKey, error)
+
+	// Revoke revokes the API key with the given prefix.
+	Revoke(prefix string) error
+}
+
+// apiKeyStore implements the ApiKeyStore interface using a database.
+type apiKeyStore struct {
+	db  db.Database
+	now func() time.Time
+}
+
+// NewApiKeyStore returns a new API key store backed by the given database.
+// The api_keys table is created if it does not exist.
+func NewApiKeyStore(d db.Database) (ApiKeyStore, error) {
+	err := d.Tx(func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&ApiKey{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &apiKeyStore{db: d, now: time.Now}, nil
+}
+
+// Create issues a new API key with the given name, scopes and expiration.
+func (s *apiKeyStore) Create(name string, scopes []string, expiresAt time.Time) (string, ApiKey, error) {
+	prefix, err := crypto.GenerateRandomString(ApiKeyPrefixLength)
+	if err != nil {
+		return "", ApiKey{}, err
+	}
+	secret, err := crypto.GenerateRandomString(ApiKeySecretLength)
+	if err != nil {
+		return "", ApiKey{}, err
+	}
+	key := prefix + "." + secret
+	record := ApiKey{
+		Prefix:    prefix,
+		Hash:      hashApiKey(key),
+		Name:      name,
+		Scopes:    strings.Join(scopes, " "),
+		CreatedAt: s.now(),
+	}
+	if !expiresAt.IsZero() {
+		record.ExpiresAt = &expiresAt
+	}
+	if err := s.db.Create(&record); err != nil {
+		return "", ApiKey{}, err
+	}
+	return key, record, nil
+}
+
+// Authenticate returns the stored API key matching the given key.
+func (s *apiKeyStore) Authenticate(key string) (ApiKey, error) {
+	prefix, _, ok := strings.Cut(key, ".")
+	if !ok || len(prefix) != ApiKeyPrefixLength {
+		return ApiKey{}, ErrApiKeyInvalid
+	}
+	record := ApiKey{}
+	if err := s.db.Read(&record, "prefix = ?", prefix); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ApiKey{}, ErrApiKeyInvalid
+		}
+		return ApiKey{}, err
+	}
+	hash := hashApiKey(key)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(record.Hash)) != 1 {
+		return ApiKey{}, ErrApiKeyInvalid
+	}
+	now := s.now()
+	if record.RevokedAt != nil && !now.Before(*record.RevokedAt) {
+		return ApiKey{}, ErrApiKeyRevoked
+	}
+	if record.ExpiresAt != nil && !now.Before(*record.ExpiresAt) {
+		return ApiKey{}, ErrApiKeyExpired
+	}
+	return record, nil
+}
+
+// Revoke revokes the API key with the given prefix.
+func (s *apiKeyStore) Revoke(prefix string) error {
+	record := ApiKey{}
+	if err := s.db.Read(&record, "prefix = ?", prefix); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrApiKeyNotFound
+		}
+		return err
+	}
+	now := s.now()
+	return s.db.Update(&ApiKey{RevokedAt: &now}, "prefix = ?", prefix)
+}
+
+// hashApiKey returns the hex encoded SHA256 hash of the key.
+func hashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ApiKeyAuth returns a middleware that authenticates requests by the API key
+// in their X-API-Key header, or their Authorization header using the ApiKey
+// scheme. Requests without a valid key are rejected with 401 Unauthorized,
+// and requests whose key could not be authenticated, e.g. because the store is
+// unavailable, with 503 Service Unavailable. The key is available to handlers
+// via GetApiKey.
+func ApiKeyAuth(store ApiKeyStore) Middleware {
+	return func(next Handler) Handler {
+		return func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			key := requestApiKey(r)
+			if key != "" {
+				record, err := store.Authenticate(key)
+				switch {
+				case err == nil:
+					ctx := context.WithValue(r.Context(), apiKeyKey, &record)
+					next(w, r.WithContext(ctx), p)
+					return
+				case !errors.Is(err, ErrApiKeyInvalid) &&
+					!errors.Is(err, ErrApiKeyRevoked) &&
+					!errors.Is(err, ErrApiKeyExpired):
+					logger.Error(fmt.Sprintf(
+						"server: failed to authenticate API key; %s",
+						err.Error(),
+					))
+					ErrorResponse(w, WrapError(
+						err,
+						ErrServiceUnavailableCode,
+						"Service is unavailable",
+					))
+					return
+				}
+			}
+			w.Header().Set("WWW-Authenticate", ApiKeyScheme)
+			JsonResponse(w, Error{
+				Code:    ErrUnauthorizedCode,
+				Message: "Unauthorized",
+			}, http.StatusUnauthorized)
+		}
+	}
+}
+
+// RequireScopes returns a middleware that rejects requests, with 403
+// Forbidden, whose API key has not been granted all of the given scopes. It
+// must be preceded by ApiKeyAuth.
+func RequireScopes(scopes ...string) Middleware {
+	return func(next Handler) Handler {
+		return func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			key := GetApiKey(r.Context())
+			for _, scope := range scopes {
+				if key == nil || !key.HasScope(scope) {
+					JsonResponse(w, Error{
+						Code:    ErrForbiddenCode,
+						Message: "Missing required scope " + scope,
+					}, http.StatusForbidden)
+					return
+				}
+			}
+			next(w, r, p)
+		}
+	}
+}
+
+// requestApiKey returns the API key of the request.
+func requestApiKey(r *http.Request) string {
+	if key := r.Header.Get(ApiKeyHeader); key != "" {
+		return key
+	}
+	scheme, key, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, ApiKeyScheme) {
+		return strings.TrimSpace(key)
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/crossedbot/common/golang/db"
+)
+
+func newTestApiKeyStore(t *testing.T) *apiKeyStore {
+	d := db.New("sqlite3")
+	require.Nil(t, d.Open(filepath.Join(t.TempDir(), "test.db")))
+	t.Cleanup(func() { d.Close() })
+	store, err := NewApiKeyStore(d)
+	require.Nil(t, err)
+	return store.(*apiKeyStore)
+}
+
+func TestApiKeyStore(t *testing.T) {
+	store := newTestApiKeyStore(t)
+	key, record, err := store.Create("partner", []string{"read", "write"}, time.Time{})
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(key, record.Prefix+"."))
+	require.NotContains(t, record.Hash, key)
+
+	actual, err := store.Authenticate(key)
+	require.Nil(t, err)
+	require.Equal(t, "partner", actual.Name)
+	require.Equal(t, []string{"read", "write"}, actual.ScopeList())
+	require.True(t, actual.HasScope("write"))
+	require.False(t, actual.HasScope("admin"))
+
+	_, err = store.Authenticate(key + "x")
+	require.Equal(t, ErrApiKeyInvalid, err)
+	_, err = store.Authenticate("abc")
+	require.Equal(t, ErrApiKeyInvalid, err)
+
+	require.Nil(t, store.Revoke(record.Prefix))
+	_, err = store.Authenticate(key)
+	require.Equal(t, ErrApiKeyRevoked, err)
+	require.Equal(t, ErrApiKeyNotFound, store.Revoke("missing"))
+}
+
+func TestApiKeyStoreExpiry(t *testing.T) {
+	store := newTestApiKeyStore(t)
+	now := time.Now()
+	key, _, err := store.Create("partner", nil, now.Add(time.Hour))
+	require.Nil(t, err)
+	_, err = store.Authenticate(key)
+	require.Nil(t, err)
+	store.now = func() time.Time { return now.Add(2 * time.Hour) }
+	_, err = store.Authenticate(key)
+	require.Equal(t, ErrApiKeyExpired, err)
+}
+
+func TestApiKeyAuth(t *testing.T) {
+	store := newTestApiKeyStore(t)
+	key, _, err := store.Create("partner", []string{"read"}, time.Time{})
+	require.Nil(t, err)
+	s := New(":8080", 10, 10).(*server)
+	s.run = 1
+	api := s.Group("/api", ApiKeyAuth(store))
+	handler := func(w http.ResponseWriter, r *http.Request, p Parameters) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(GetApiKey(r.Context()).Name))
+	}
+	require.Nil(t, api.Handle(handler, http.MethodGet, "/read", RequireScopes("read")))
+	require.Nil(t, api.Handle(handler, http.MethodGet, "/admin", RequireScopes("admin")))
+	request := func(path, header, value string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(http.MethodGet, path, nil)
+		require.Nil(t, err)
+		r.Header.Set(header, value)
+		rr := httptest.NewRecorder()
+		s.handler().ServeHTTP(rr, r)
+		return rr
+	}
+	rr := request("/api/read", ApiKeyHeader, key)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "partner", rr.Body.String())
+	rr = request("/api/read", "Authorization", "ApiKey "+key)
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr = request("/api/admin", ApiKeyHeader, key)
+	require.Equal(t, http.StatusForbidden, rr.Code)
+	require.Contains(t, rr.Body.String(), `"code":1010`)
+	rr = request("/api/read", ApiKeyHeader, "bad.key")
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+	require.Equal(t, ApiKeyScheme, rr.Header().Get("WWW-Authenticate"))
+
+	// Failures of the store are not reported as invalid keys
+	buf := captureLog(t)
+	require.Nil(t, store.db.Close())
+	rr = request("/api/read", ApiKeyHeader, key)
+	require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	require.Equal(t, "", rr.Header().Get("WWW-Authenticate"))
+	require.Contains(t, rr.Body.String(), `"code":1003`)
+	require.Contains(t, buf.String(), "failed to authenticate API key")
+}
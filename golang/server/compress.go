@@ -0,0 +1,344 @@
+package server
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/crossedbot/common/golang/logger"
+)
+
+const (
+	// EncodingGzip is the gzip content coding.
+	EncodingGzip = "gzip"
+
+	// EncodingDeflate is the deflate content coding.
+	EncodingDeflate = "deflate"
+
+	// DefaultCompressMinSize is the default minimum size, in bytes, of a
+	// compressed response body.
+	DefaultCompressMinSize = 1024
+)
+
+// DefaultCompressContentTypes are the media ranges compressed by default.
+var DefaultCompressContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/problem+json",
+	"application/xml",
+	"application/javascript",
+	"image/svg+xml",
+}
+
+// CompressOptions represents the configuration of the compression middleware.
+type CompressOptions struct {
+	// Level is the compression level, from flate.BestSpeed to
+	// flate.BestCompression; defaults to flate.DefaultCompression, which
+	// is also used if the level is invalid.
+	Level int
+
+	// MinSize is the minimum size, in bytes, of a response body to be
+	// compressed; defaults to DefaultCompressMinSize. Flushed responses are
+	// compressed regardless of their size.
+	MinSize int
+
+	// ContentTypes are the media ranges, e.g. "text/*", of the responses
+	// that are compressed; defaults to DefaultCompressContentTypes.
+	ContentTypes []string
+
+	// Encodings are the supported content codings in order of preference;
+	// defaults to gzip and deflate. Unsupported codings are ignored.
+	Encodings []string
+}
+
+// compressor represents a pooled compressing writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Compress returns a middleware that compresses responses with the content
+// coding best matching the request's Accept-Encoding header. Responses are
+// buffered until they reach the minimum size, are flushed, or complete; only
+// then is it decided whether they are compressed. Responses that are already
+// encoded, partial, have no body, or have a content type that is not allowed
+// are written unchanged. Invalid options are logged and ignored.
+func Compress(opts CompressOptions) Middleware {
+	if opts.Level == 0 {
+		opts.Level = flate.DefaultCompression
+	}
+	if _, err := flate.NewWriter(io.Discard, opts.Level); err != nil {
+		logger.Error(fmt.Sprintf(
+			"server: invalid compression level %d; using the default",
+			opts.Level,
+		))
+		opts.Level = flate.DefaultCompression
+	}
+	if opts.MinSize <= 0 {
+		opts.MinSize = DefaultCompressMinSize
+	}
+	if len(opts.ContentTypes) == 0 {
+		opts.ContentTypes = DefaultCompressContentTypes
+	}
+	if len(opts.Encodings) == 0 {
+		opts.Encodings = []string{EncodingGzip, EncodingDeflate}
+	}
+	pools := map[string]*sync.Pool{}
+	encodings := []string{}
+	for _, enc := range opts.Encodings {
+		pool, err := compressorPool(enc, opts.Level)
+		if err != nil {
+			logger.Error(fmt.Sprintf("server: %s; ignoring it", err.Error()))
+			continue
+		}
+		pools[enc] = pool
+		encodings = append(encodings, enc)
+	}
+	opts.Encodings = encodings
+	return func(next Handler) Handler {
+		return func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(
+				r.Header.Get("Accept-Encoding"),
+				opts.Encodings,
+			)
+			if encoding == "" || r.Method == http.MethodHead {
+				next(w, r, p)
+				return
+			}
+			cw := &compressWriter{
+				ResponseWriter: w,
+				opts:           &opts,
+				encoding:       encoding,
+				pool:           pools[encoding],
+				status:         http.StatusOK,
+			}
+			defer cw.close()
+			next(cw, r, p)
+		}
+	}
+}
+
+// compressorPool returns a pool of compressors for the content coding at the
+// given, valid, level.
+func compressorPool(encoding string, level int) (*sync.Pool, error) {
+	switch encoding {
+	case EncodingGzip:
+		return &sync.Pool{New: func() interface{} {
+			w, _ := gzip.NewWriterLevel(io.Discard, level)
+			return w
+		}}, nil
+	case EncodingDeflate:
+		return &sync.Pool{New: func() interface{} {
+			w, _ := flate.NewWriter(io.Discard, level)
+			return w
+		}}, nil
+	}
+	return nil, fmt.Errorf("unsupported content coding %q", encoding)
+}
+
+// negotiateEncoding returns the supported content coding best matching the
+// Accept-Encoding header, or an empty string if none is acceptable.
+func negotiateEncoding(acceptEncoding string, encodings []string) string {
+	accepted := parseAccept(acceptEncoding)
+	for _, a := range accepted {
+		if a.quality <= 0 {
+			continue
+		}
+		if a.mediaType == "*" {
+			for _, enc := range encodings {
+				if !rejected(accepted, enc) {
+					return enc
+				}
+			}
+			continue
+		}
+		if containsAny(encodings, a.mediaType) {
+			return a.mediaType
+		}
+	}
+	return ""
+}
+
+// compressWriter wraps an http.ResponseWriter to compress the response body.
+type compressWriter struct {
+	http.ResponseWriter
+	opts        *CompressOptions
+	encoding    string     // negotiated content coding
+	pool        *sync.Pool // pool of compressors for the content coding
+	comp        compressor // compressor; set if the response is compressed
+	buf         []byte     // body buffered until the decision is made
+	status      int        // response status code
+	wroteHeader bool       // indicates whether the handler wrote the header
+	decided     bool       // indicates whether the decision has been made
+}
+
+// WriteHeader records the status code. The header is written once it is
+// decided whether the response is compressed.
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+	w.wroteHeader = true
+	// Responses that will never be compressed are not buffered
+	if !w.compressible(-1) {
+		w.decide(false)
+	}
+}
+
+// Write buffers the body until the minimum size is reached, then writes it
+// through the compressor, if compressing, or unchanged.
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		if len(w.buf)+len(b) < w.opts.MinSize {
+			w.buf = append(w.buf, b...)
+			return len(b), nil
+		}
+		w.buf = append(w.buf, b...)
+		if err := w.decide(w.compressible(len(w.buf))); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.comp != nil {
+		return w.comp.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush decides whether the response is compressed, regardless of its size,
+// and flushes the compressed data to the client.
+func (w *compressWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.decide(w.compressible(w.opts.MinSize))
+	}
+	if w.comp != nil {
+		w.comp.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection if supported by the
+// underlying writer.
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("hijacking is not supported")
+}
+
+// Unwrap returns the underlying response writer.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// compressible returns true if the response can be compressed given the size
+// of its body; a negative size indicates it is not yet known.
+func (w *compressWriter) compressible(size int) bool {
+	h := w.Header()
+	switch {
+	case w.status < 200,
+		w.status == http.StatusNoContent,
+		w.status == http.StatusNotModified,
+		w.status == http.StatusPartialContent,
+		h.Get("Content-Encoding") != "",
+		h.Get("Content-Range") != "",
+		strings.Contains(h.Get("Cache-Control"), "no-transform"):
+		return false
+	}
+	if size < 0 {
+		if l, err := strconv.Atoi(h.Get("Content-Length")); err == nil {
+			size = l
+		}
+	}
+	if size >= 0 && size < w.opts.MinSize {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		if size < 0 {
+			// The content type is sniffed once the body is buffered
+			return true
+		}
+		contentType = http.DetectContentType(w.buf)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, mediaRange := range w.opts.ContentTypes {
+		if matchMediaType(mediaRange, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// decide writes the header, compressed or not, followed by the buffered body.
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+	if compress {
+		h := w.Header()
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		// The compressed representation is not byte-for-byte identical
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		w.comp = w.pool.Get().(compressor)
+		w.comp.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.comp != nil {
+		_, err = w.comp.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close decides whether a buffered response is compressed, and completes and
+// releases the compressor.
+func (w *compressWriter) close() {
+	if !w.decided {
+		if !w.wroteHeader {
+			// The handler wrote nothing; leave the response untouched
+			return
+		}
+		w.decide(len(w.buf) > 0 && w.compressible(len(w.buf)))
+	}
+	if w.comp != nil {
+		w.comp.Close()
+		w.comp.Reset(io.Discard)
+		w.pool.Put(w.comp)
+		w.comp = nil
+	}
+}
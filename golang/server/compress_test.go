@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func compress(t *testing.T, opts CompressOptions, acceptEncoding string, handler Handler) *httptest.ResponseRecorder {
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	require.Nil(t, err)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	rr := httptest.NewRecorder()
+	Compress(opts)(handler)(rr, r, nil)
+	return rr
+}
+
+func gunzip(t *testing.T, b []byte) string {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	require.Nil(t, err)
+	out, err := io.ReadAll(zr)
+	require.Nil(t, err)
+	return string(out)
+}
+
+func TestCompressGzip(t *testing.T) {
+	data := map[string]string{"message": strings.Repeat("hello ", 500)}
+	rr := compress(t, CompressOptions{}, "gzip, deflate",
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			w.Header().Set("Content-Length", "3000")
+			w.Header().Set("ETag", `"v1"`)
+			JsonResponse(w, data, http.StatusCreated)
+		},
+	)
+	require.Equal(t, http.StatusCreated, rr.Code)
+	require.Equal(t, EncodingGzip, rr.Header().Get("Content-Encoding"))
+	require.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+	require.Empty(t, rr.Header().Get("Content-Length"))
+	require.Equal(t, `W/"v1"`, rr.Header().Get("ETag"))
+	require.Less(t, rr.Body.Len(), 3000)
+	require.Contains(t, gunzip(t, rr.Body.Bytes()), data["message"])
+}
+
+func TestCompressDeflate(t *testing.T) {
+	body := strings.Repeat("a", 2048)
+	rr := compress(t, CompressOptions{}, "gzip;q=0.5, deflate",
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			io.WriteString(w, body)
+		},
+	)
+	require.Equal(t, EncodingDeflate, rr.Header().Get("Content-Encoding"))
+	out, err := io.ReadAll(flate.NewReader(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, body, string(out))
+}
+
+func TestCompressSkipped(t *testing.T) {
+	large := strings.Repeat("a", 2048)
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		handler        Handler
+	}{
+		{"not accepted", "", func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			io.WriteString(w, large)
+		}},
+		{"identity", "identity, gzip;q=0", func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			io.WriteString(w, large)
+		}},
+		{"small", "gzip", func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			io.WriteString(w, "small")
+		}},
+		{"content type", "gzip", func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, large)
+		}},
+		{"encoded", "gzip", func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, large)
+		}},
+		{"partial", "gzip", func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusPartialContent)
+			io.WriteString(w, large)
+		}},
+	}
+	for _, tt := range tests {
+		rr := compress(t, CompressOptions{}, tt.acceptEncoding, tt.handler)
+		require.NotEqual(t, EncodingGzip, rr.Header().Get("Content-Encoding"), tt.name)
+		require.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"), tt.name)
+		require.NotEmpty(t, rr.Body.String(), tt.name)
+		require.False(t, strings.Contains(rr.Body.String(), "\x1f\x8b"), tt.name)
+	}
+}
+
+func TestCompressStreaming(t *testing.T) {
+	flushed := false
+	rr := compress(t, CompressOptions{}, "gzip",
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: one\n\n")
+			w.(http.Flusher).Flush()
+			flushed = w.Header().Get("Content-Encoding") == EncodingGzip
+			io.WriteString(w, "data: two\n\n")
+		},
+	)
+	require.True(t, flushed)
+	require.True(t, rr.Flushed)
+	require.Equal(t, "data: one\n\ndata: two\n\n", gunzip(t, rr.Body.Bytes()))
+}
+
+func TestCompressOptions(t *testing.T) {
+	rr := compress(t, CompressOptions{
+		MinSize:      10,
+		ContentTypes: []string{"application/octet-stream"},
+		Encodings:    []string{EncodingGzip},
+	}, "deflate, gzip;q=0.1",
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(bytes.Repeat([]byte{0}, 64))
+		},
+	)
+	require.Equal(t, EncodingGzip, rr.Header().Get("Content-Encoding"))
+}
+
+func TestCompressInvalidOptions(t *testing.T) {
+	buf := captureLog(t)
+	data := strings.Repeat("hello ", 500)
+	handler := func(w http.ResponseWriter, r *http.Request, p Parameters) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(data))
+	}
+	rr := compress(t, CompressOptions{
+		Level:     42,
+		Encodings: []string{"br", EncodingGzip},
+	}, "br, gzip", handler)
+	require.Equal(t, EncodingGzip, rr.Header().Get("Content-Encoding"))
+	require.Equal(t, data, gunzip(t, rr.Body.Bytes()))
+	require.Contains(t, buf.String(), "invalid compression level 42")
+	require.Contains(t, buf.String(), `unsupported content coding \"br\"`)
+
+	rr = compress(t, CompressOptions{Encodings: []string{"br"}}, "br", handler)
+	require.Equal(t, "", rr.Header().Get("Content-Encoding"))
+	require.Equal(t, data, rr.Body.String())
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	encodings := []string{EncodingGzip, EncodingDeflate}
+	require.Equal(t, EncodingGzip, negotiateEncoding("gzip, deflate", encodings))
+	require.Equal(t, EncodingDeflate, negotiateEncoding("gzip;q=0.2, deflate;q=0.8", encodings))
+	require.Equal(t, EncodingGzip, negotiateEncoding("*", encodings))
+	require.Equal(t, EncodingDeflate, negotiateEncoding("*, gzip;q=0", encodings))
+	require.Equal(t, "", negotiateEncoding("br, identity", encodings))
+	require.Equal(t, "", negotiateEncoding("", encodings))
+}
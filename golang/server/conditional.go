@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ConditionalOptions represents the configuration of the conditional request
+// middleware.
+type ConditionalOptions struct {
+	// ETag returns the current ETag of the resource targeted by a PUT,
+	// PATCH or DELETE request; an empty ETag indicates that the resource
+	// does not exist. Preconditions of these requests are only evaluated
+	// if it is set.
+	ETag func(r *http.Request, p Parameters) (string, error)
+}
+
+// Conditional returns a middleware handling conditional requests.
+//
+// Successful responses to GET and HEAD requests are buffered and given a
+// strong ETag computed over the body, unless the handler set one. If the
+// ETag matches If-None-Match, or Last-Modified is not after If-Modified-Since
+// when there is no If-None-Match, 304 Not Modified is written instead of the
+// body. Flushed responses are not buffered and are written unchanged.
+//
+// For PUT, PATCH and DELETE requests, If-Match and If-None-Match are evaluated
+// against the resource's current ETag; writing 412 Precondition Failed if they
+// do not hold. If-Match uses strong comparison, so weak ETags, e.g. those of
+// compressed responses, never match.
+func Conditional(opts ConditionalOptions) Middleware {
+	return func(next Handler) Handler {
+		return func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead:
+				cw := &conditionalWriter{ResponseWriter: w, status: http.StatusOK}
+				next(cw, r, p)
+				cw.close(r)
+			case http.MethodPut, http.MethodPatch, http.MethodDelete:
+				if opts.ETag != nil && !preconditions(w, r, p, opts.ETag) {
+					return
+				}
+				next(w, r, p)
+			default:
+				next(w, r, p)
+			}
+		}
+	}
+}
+
+// preconditions evaluates the If-Match and If-None-Match headers of the request
+// against the resource's current ETag; writing an error and returning false if
+// they do not hold.
+func preconditions(w http.ResponseWriter, r *http.Request, p Parameters, current func(*http.Request, Parameters) (string, error)) bool {
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return true
+	}
+	etag, err := current(r, p)
+	if err != nil {
+		ErrorResponse(w, err)
+		return false
+	}
+	if (ifMatch != "" && !matchETag(ifMatch, etag, true)) ||
+		(ifNoneMatch != "" && matchETag(ifNoneMatch, etag, false)) {
+		JsonResponse(w, Error{
+			Code:    ErrPreconditionFailedCode,
+			Message: "Precondition failed",
+		}, http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// NewETag returns a strong ETag for the given representation.
+func NewETag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return etagOf(sum[:])
+}
+
+// etagOf returns a strong ETag for the given digest.
+func etagOf(sum []byte) string {
+	return fmt.Sprintf("%q", hex.EncodeToString(sum[:16]))
+}
+
+// matchETag returns true if the ETag is in the list of an If-Match or
+// If-None-Match header. A "*" matches any existing resource. Strong comparison
+// requires both ETags be strong; weak comparison ignores the weak indicator.
+func matchETag(list, etag string, strong bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strings.HasPrefix(candidate, "W/") {
+			if strong {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified returns true if the representation described by the response
+// header has not been modified according to the request's conditions.
+func notModified(r *http.Request, h http.Header) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return matchETag(ifNoneMatch, h.Get("ETag"), false)
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// conditionalWriter wraps an http.ResponseWriter to buffer the response until
+// its ETag is known.
+type conditionalWriter struct {
+	http.ResponseWriter
+	status      int          // response status code
+	buf         bytes.Buffer // buffered response body
+	wroteHeader bool         // indicates whether the handler wrote the header
+	streaming   bool         // indicates whether the response is unbuffered
+}
+
+// WriteHeader records the status code; the header is written once the
+// response is complete.
+func (w *conditionalWriter) WriteHeader(status int) {
+	if w.streaming {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.wroteHeader {
+		return
+	}
+	w.status = status
+	w.wroteHeader = true
+}
+
+// Write buffers the response body.
+func (w *conditionalWriter) Write(b []byte) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.Write(b)
+	}
+	w.wroteHeader = true
+	return w.buf.Write(b)
+}
+
+// Flush stops buffering the response; writing it unchanged.
+func (w *conditionalWriter) Flush() {
+	if !w.streaming {
+		w.streaming = true
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection if supported by the
+// underlying writer.
+func (w *conditionalWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.streaming = true
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("hijacking is not supported")
+}
+
+// Unwrap returns the underlying response writer.
+func (w *conditionalWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close writes the buffered response; or 304 Not Modified if the request's
+// conditions hold.
+func (w *conditionalWriter) close(r *http.Request) {
+	if w.streaming || !w.wroteHeader {
+		return
+	}
+	h := w.Header()
+	if w.status == http.StatusOK {
+		if h.Get("ETag") == "" {
+			h.Set("ETag", NewETag(w.buf.Bytes()))
+		}
+		if notModified(r, h) {
+			h.Del("Content-Type")
+			h.Del("Content-Length")
+			w.ResponseWriter.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.buf.Bytes())
+}
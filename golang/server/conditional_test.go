@@ -0,0 +1,148 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func conditional(opts ConditionalOptions, method string, headers map[string]string, handler Handler) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/items/1", nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	Conditional(opts)(handler)(rr, r, nil)
+	return rr
+}
+
+func TestConditionalIfNoneMatch(t *testing.T) {
+	item := map[string]string{"id": "1"}
+	handler := func(w http.ResponseWriter, r *http.Request, p Parameters) {
+		JsonResponse(w, item, http.StatusOK)
+	}
+	rr := conditional(ConditionalOptions{}, http.MethodGet, nil, handler)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, `{"id":"1"}`, rr.Body.String())
+	etag := rr.Header().Get("ETag")
+	require.Equal(t, NewETag(rr.Body.Bytes()), etag)
+
+	rr = conditional(ConditionalOptions{}, http.MethodGet, map[string]string{
+		"If-None-Match": `"other", ` + etag,
+	}, handler)
+	require.Equal(t, http.StatusNotModified, rr.Code)
+	require.Empty(t, rr.Body.String())
+	require.Empty(t, rr.Header().Get("Content-Type"))
+	require.Equal(t, etag, rr.Header().Get("ETag"))
+
+	// Weak comparison is used for If-None-Match
+	rr = conditional(ConditionalOptions{}, http.MethodGet, map[string]string{
+		"If-None-Match": "W/" + etag,
+	}, handler)
+	require.Equal(t, http.StatusNotModified, rr.Code)
+
+	item["id"] = "2"
+	rr = conditional(ConditionalOptions{}, http.MethodGet, map[string]string{
+		"If-None-Match": etag,
+	}, handler)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, `{"id":"2"}`, rr.Body.String())
+}
+
+func TestConditionalIfModifiedSince(t *testing.T) {
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	handler := func(w http.ResponseWriter, r *http.Request, p Parameters) {
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("body"))
+	}
+	rr := conditional(ConditionalOptions{}, http.MethodGet, map[string]string{
+		"If-Modified-Since": modified.Format(http.TimeFormat),
+	}, handler)
+	require.Equal(t, http.StatusNotModified, rr.Code)
+	require.Equal(t, `"v1"`, rr.Header().Get("ETag"))
+
+	rr = conditional(ConditionalOptions{}, http.MethodGet, map[string]string{
+		"If-Modified-Since": modified.Add(-time.Hour).Format(http.TimeFormat),
+	}, handler)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	// If-None-Match takes precedence over If-Modified-Since
+	rr = conditional(ConditionalOptions{}, http.MethodGet, map[string]string{
+		"If-None-Match":     `"v0"`,
+		"If-Modified-Since": modified.Format(http.TimeFormat),
+	}, handler)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "body", rr.Body.String())
+}
+
+func TestConditionalSkipped(t *testing.T) {
+	rr := conditional(ConditionalOptions{}, http.MethodGet, map[string]string{
+		"If-None-Match": "*",
+	}, func(w http.ResponseWriter, r *http.Request, p Parameters) {
+		JsonResponse(w, Error{Code: ErrNotFoundCode}, http.StatusNotFound)
+	})
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	require.Empty(t, rr.Header().Get("ETag"))
+
+	rr = conditional(ConditionalOptions{}, http.MethodGet, nil,
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			w.Write([]byte("data: one\n\n"))
+			w.(http.Flusher).Flush()
+			w.Write([]byte("data: two\n\n"))
+		},
+	)
+	require.True(t, rr.Flushed)
+	require.Empty(t, rr.Header().Get("ETag"))
+	require.Equal(t, "data: one\n\ndata: two\n\n", rr.Body.String())
+}
+
+func TestConditionalIfMatch(t *testing.T) {
+	current := `"v2"`
+	opts := ConditionalOptions{
+		ETag: func(r *http.Request, p Parameters) (string, error) {
+			return current, nil
+		},
+	}
+	called := 0
+	handler := func(w http.ResponseWriter, r *http.Request, p Parameters) {
+		called++
+		w.WriteHeader(http.StatusNoContent)
+	}
+	tests := []struct {
+		method  string
+		headers map[string]string
+		status  int
+	}{
+		{http.MethodPut, nil, http.StatusNoContent},
+		{http.MethodPut, map[string]string{"If-Match": `"v2"`}, http.StatusNoContent},
+		{http.MethodPatch, map[string]string{"If-Match": `"v1", "v2"`}, http.StatusNoContent},
+		{http.MethodDelete, map[string]string{"If-Match": "*"}, http.StatusNoContent},
+		{http.MethodPut, map[string]string{"If-Match": `"v1"`}, http.StatusPreconditionFailed},
+		{http.MethodPut, map[string]string{"If-Match": `W/"v2"`}, http.StatusPreconditionFailed},
+		{http.MethodPut, map[string]string{"If-None-Match": "*"}, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		rr := conditional(opts, tt.method, tt.headers, handler)
+		require.Equal(t, tt.status, rr.Code, tt.headers)
+	}
+	require.Equal(t, 4, called)
+
+	// The resource does not exist
+	current = ""
+	rr := conditional(opts, http.MethodPut, map[string]string{"If-Match": "*"}, handler)
+	require.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	require.Contains(t, rr.Body.String(), "Precondition failed")
+	rr = conditional(opts, http.MethodPut, map[string]string{"If-None-Match": "*"}, handler)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+
+	opts.ETag = func(r *http.Request, p Parameters) (string, error) {
+		return "", errors.New("failed")
+	}
+	rr = conditional(opts, http.MethodPut, map[string]string{"If-Match": "*"}, handler)
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+}
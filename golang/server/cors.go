@@ -0,0 +1,232 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CorsPolicy represents a Cross-Origin Resource Sharing policy.
+type CorsPolicy struct {
+	// AllowedOrigins is a list of origins allowed to make cross-origin
+	// requests. An origin may contain a single "*" wildcard, e.g.
+	// "https://*.example.com", and "*" allows all origins unless
+	// credentials are allowed.
+	AllowedOrigins []string
+
+	// AllowedOriginPatterns is a list of regular expressions matching
+	// origins allowed to make cross-origin requests.
+	AllowedOriginPatterns []*regexp.Regexp
+
+	// AllowedMethods is a list of methods allowed for cross-origin
+	// requests; defaults to GET, HEAD, POST, PUT, PATCH and DELETE.
+	AllowedMethods []string
+
+	// AllowedHeaders is a list of request headers allowed for cross-origin
+	// requests; "*" allows all headers. An empty list allows all headers
+	// requested by a preflight request.
+	AllowedHeaders []string
+
+	// ExposedHeaders is a list of response headers exposed to the client.
+	ExposedHeaders []string
+
+	// AllowCredentials indicates whether requests may include credentials,
+	// e.g. cookies.
+	AllowCredentials bool
+
+	// MaxAge is how long the results of a preflight request may be cached.
+	MaxAge time.Duration
+}
+
+// defaultCorsMethods is the default list of methods allowed for cross-origin
+// requests.
+var defaultCorsMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// cors applies a CORS policy to the requests of a route.
+type cors struct {
+	policy        CorsPolicy // policy applied
+	methods       []string   // methods allowed for cross-origin requests
+	allowMethods  string     // Access-Control-Allow-Methods value
+	allowHeaders  string     // Access-Control-Allow-Headers value
+	exposeHeaders string     // Access-Control-Expose-Headers value
+}
+
+// Cors returns a route option applying the CORS policy to a route, or to all
+// routes of a group. The policy is applied before the route's middleware,
+// regardless of the order of the options, so preflight requests are answered
+// before middleware such as authentication is executed; since the server
+// answers OPTIONS requests for every added path, preflight requests never
+// reach a handler. A policy given to a route replaces that of its group, which
+// replaces the server's; see WithCors.
+func Cors(policy CorsPolicy) RouteOption {
+	return newCors(policy)
+}
+
+// newCors returns a new cors for the given policy.
+func newCors(policy CorsPolicy) *cors {
+	methods := policy.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCorsMethods
+	}
+	return &cors{
+		policy:        policy,
+		methods:       methods,
+		allowMethods:  strings.Join(methods, ", "),
+		allowHeaders:  strings.Join(policy.AllowedHeaders, ", "),
+		exposeHeaders: strings.Join(policy.ExposedHeaders, ", "),
+	}
+}
+
+// applyRoute sets the CORS policy of the route configuration.
+func (c *cors) applyRoute(cfg *routeConfig) {
+	cfg.cors = c
+}
+
+// handler returns a handler applying the CORS policy to requests before
+// calling the next handler. Preflight requests are answered without calling
+// the next handler.
+func (c *cors) handler(next Handler) Handler {
+	policy := c.policy
+	return func(w http.ResponseWriter, r *http.Request, p Parameters) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions &&
+			r.Header.Get("Access-Control-Request-Method") != ""
+		h := w.Header()
+		// The response depends on the origin even without one, so that
+		// caches do not serve it to cross-origin requests
+		h.Add("Vary", "Origin")
+		if origin == "" {
+			next(w, r, p)
+			return
+		}
+		if preflight {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+		}
+		if !policy.allowOrigin(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next(w, r, p)
+			return
+		}
+		if preflight {
+			method := r.Header.Get("Access-Control-Request-Method")
+			requested := r.Header.Get("Access-Control-Request-Headers")
+			if !containsFold(c.methods, method) ||
+				!policy.allowHeaders(requested) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		h.Set("Access-Control-Allow-Origin", origin)
+		if policy.allowAnyOrigin() && !policy.AllowCredentials {
+			h.Set("Access-Control-Allow-Origin", "*")
+		}
+		if policy.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			if c.exposeHeaders != "" {
+				h.Set("Access-Control-Expose-Headers", c.exposeHeaders)
+			}
+			next(w, r, p)
+			return
+		}
+		h.Set("Access-Control-Allow-Methods", c.allowMethods)
+		if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+			if c.allowHeaders == "" || c.allowHeaders == "*" {
+				h.Set("Access-Control-Allow-Headers", requested)
+			} else {
+				h.Set("Access-Control-Allow-Headers", c.allowHeaders)
+			}
+		}
+		if policy.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age",
+				strconv.Itoa(int(policy.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// allowAnyOrigin returns true if the policy allows all origins.
+func (policy CorsPolicy) allowAnyOrigin() bool {
+	for _, o := range policy.AllowedOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin returns true if the policy allows the origin. When credentials
+// are allowed, the "*" origin allows none; origins must be listed or match a
+// pattern.
+func (policy CorsPolicy) allowOrigin(origin string) bool {
+	for _, o := range policy.AllowedOrigins {
+		if o == "*" && policy.AllowCredentials {
+			continue
+		}
+		if matchOrigin(o, origin) {
+			return true
+		}
+	}
+	for _, re := range policy.AllowedOriginPatterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowHeaders returns true if the policy allows all of the comma separated
+// request headers.
+func (policy CorsPolicy) allowHeaders(requested string) bool {
+	if len(policy.AllowedHeaders) == 0 ||
+		containsFold(policy.AllowedHeaders, "*") {
+		return true
+	}
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !containsFold(policy.AllowedHeaders, header) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchOrigin returns true if the origin matches the allowed origin; which may
+// contain a single "*" wildcard.
+func matchOrigin(allowed, origin string) bool {
+	if allowed == "*" || strings.EqualFold(allowed, origin) {
+		return true
+	}
+	prefix, suffix, ok := strings.Cut(allowed, "*")
+	if !ok {
+		return false
+	}
+	origin = strings.ToLower(origin)
+	return len(origin) > len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, strings.ToLower(prefix)) &&
+		strings.HasSuffix(origin, strings.ToLower(suffix))
+}
+
+// containsFold returns true if the list contains the value, ignoring case.
+func containsFold(list []string, value string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newCorsServer(t *testing.T, policy CorsPolicy) *server {
+	s := New(":8080", 10, 10).(*server)
+	s.run = 1
+	api := s.Group("/api", Cors(policy))
+	require.Nil(t, api.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			w.WriteHeader(http.StatusOK)
+		},
+		http.MethodGet, "/users/:id",
+	))
+	return s
+}
+
+func TestCorsPreflight(t *testing.T) {
+	s := newCorsServer(t, CorsPolicy{
+		AllowedOrigins: []string{"https://*.example.com"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+		MaxAge:         time.Hour,
+	})
+	r, err := http.NewRequest(http.MethodOptions, "/api/users/abc", nil)
+	require.Nil(t, err)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	r.Header.Set("Access-Control-Request-Headers", "authorization")
+	rr := httptest.NewRecorder()
+	s.handler().ServeHTTP(rr, r)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	require.Equal(t, "https://app.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+	require.Contains(t, rr.Header().Get("Access-Control-Allow-Methods"), http.MethodPut)
+	require.Equal(t, "Authorization, Content-Type", rr.Header().Get("Access-Control-Allow-Headers"))
+	require.Equal(t, "3600", rr.Header().Get("Access-Control-Max-Age"))
+
+	r.Header.Set("Access-Control-Request-Headers", "x-unknown")
+	rr = httptest.NewRecorder()
+	s.handler().ServeHTTP(rr, r)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	require.Equal(t, "", rr.Header().Get("Access-Control-Allow-Origin"))
+
+	r.Header.Set("Origin", "https://example.org")
+	rr = httptest.NewRecorder()
+	s.handler().ServeHTTP(rr, r)
+	require.Equal(t, "", rr.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCorsRequest(t *testing.T) {
+	s := newCorsServer(t, CorsPolicy{
+		AllowedOrigins:        []string{"https://app.example.com"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`^http://localhost:\d+$`)},
+		ExposedHeaders:        []string{RequestIdHeader},
+		AllowCredentials:      true,
+	})
+	request := func(origin string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(http.MethodGet, "/api/users/abc", nil)
+		require.Nil(t, err)
+		r.Header.Set("Origin", origin)
+		rr := httptest.NewRecorder()
+		s.handler().ServeHTTP(rr, r)
+		require.Equal(t, http.StatusOK, rr.Code)
+		return rr
+	}
+	rr := request("http://localhost:3000")
+	require.Equal(t, "http://localhost:3000", rr.Header().Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"))
+	require.Equal(t, RequestIdHeader, rr.Header().Get("Access-Control-Expose-Headers"))
+	require.Equal(t, "Origin", rr.Header().Get("Vary"))
+	rr = request("https://evil.example.com")
+	require.Equal(t, "", rr.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCorsAnyOrigin(t *testing.T) {
+	s := newCorsServer(t, CorsPolicy{AllowedOrigins: []string{"*"}})
+	r, err := http.NewRequest(http.MethodGet, "/api/users/abc", nil)
+	require.Nil(t, err)
+	r.Header.Set("Origin", "https://example.org")
+	rr := httptest.NewRecorder()
+	s.handler().ServeHTTP(rr, r)
+	require.Equal(t, "*", rr.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCorsAnyOriginCredentials(t *testing.T) {
+	s := newCorsServer(t, CorsPolicy{
+		AllowedOrigins:   []string{"*", "https://app.example.com"},
+		AllowCredentials: true,
+	})
+	request := func(origin string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(http.MethodGet, "/api/users/abc", nil)
+		require.Nil(t, err)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		rr := httptest.NewRecorder()
+		s.handler().ServeHTTP(rr, r)
+		require.Equal(t, http.StatusOK, rr.Code)
+		return rr
+	}
+	rr := request("https://evil.example")
+	require.Equal(t, "", rr.Header().Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "", rr.Header().Get("Access-Control-Allow-Credentials"))
+	rr = request("https://app.example.com")
+	require.Equal(t, "https://app.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"))
+
+	// Responses to same-origin requests vary by origin too
+	rr = request("")
+	require.Equal(t, "Origin", rr.Header().Get("Vary"))
+	require.Equal(t, "", rr.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCorsBeforeAuth(t *testing.T) {
+	v, err := NewJwtVerifier(JwtOptions{
+		Keys: map[string]interface{}{"": []byte("secret")},
+	})
+	require.Nil(t, err)
+	policy := CorsPolicy{AllowedOrigins: []string{"https://app.example.com"}}
+	for name, s := range map[string]*server{
+		"server": New(":8080", 10, 10, WithCors(policy)).(*server),
+		"group":  New(":8080", 10, 10).(*server),
+	} {
+		s.run = 1
+		s.Use(JwtAuth(v))
+		rtr := Router(s)
+		if name == "group" {
+			rtr = s.Group("/", Cors(policy))
+		}
+		require.Nil(t, rtr.Add(
+			func(w http.ResponseWriter, r *http.Request, p Parameters) {
+				w.WriteHeader(http.StatusOK)
+			},
+			http.MethodGet, "/users/:id",
+		))
+
+		// Preflight requests are answered before authentication
+		r, err := http.NewRequest(http.MethodOptions, "/users/abc", nil)
+		require.Nil(t, err)
+		r.Header.Set("Origin", "https://app.example.com")
+		r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		rr := httptest.NewRecorder()
+		s.handler().ServeHTTP(rr, r)
+		require.Equal(t, http.StatusNoContent, rr.Code, name)
+		require.Equal(t, "https://app.example.com",
+			rr.Header().Get("Access-Control-Allow-Origin"), name)
+
+		// Plain OPTIONS requests are answered without middleware
+		r, err = http.NewRequest(http.MethodOptions, "/users/abc", nil)
+		require.Nil(t, err)
+		rr = httptest.NewRecorder()
+		s.handler().ServeHTTP(rr, r)
+		require.Equal(t, http.StatusNoContent, rr.Code, name)
+		require.Equal(t, "GET, OPTIONS", rr.Header().Get("Allow"), name)
+
+		// Rejected requests carry the CORS headers
+		r, err = http.NewRequest(http.MethodGet, "/users/abc", nil)
+		require.Nil(t, err)
+		r.Header.Set("Origin", "https://app.example.com")
+		rr = httptest.NewRecorder()
+		s.handler().ServeHTTP(rr, r)
+		require.Equal(t, http.StatusUnauthorized, rr.Code, name)
+		require.Equal(t, "https://app.example.com",
+			rr.Header().Get("Access-Control-Allow-Origin"), name)
+	}
+}
+
+func TestMatchOrigin(t *testing.T) {
+	require.True(t, matchOrigin("*", "https://example.org"))
+	require.True(t, matchOrigin("https://example.org", "https://EXAMPLE.org"))
+	require.True(t, matchOrigin("https://*.example.org", "https://a.b.example.org"))
+	require.False(t, matchOrigin("https://*.example.org", "https://.example.org"))
+	require.False(t, matchOrigin("https://*.example.org", "https://example.org"))
+	require.False(t, matchOrigin("https://example.org", "https://example.com"))
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxBodyBytes is the default maximum size of a decoded request body.
+const DefaultMaxBodyBytes = 1 << 20 // 1 MiB
+
+// DecodeOptions represents the options for decoding a request body.
+type DecodeOptions struct {
+	// MaxBytes is the maximum size of the request body; defaults to
+	// DefaultMaxBodyBytes.
+	MaxBytes int64
+
+	// DisallowUnknownFields causes fields in the body that do not match
+	// the destination to be rejected.
+	DisallowUnknownFields bool
+
+	// AnyContentType disables checking that the request's content type is
+	// JSON.
+	AnyContentType bool
+}
+
+// DecodeJson decodes the JSON request body into the value pointed to by v. The
+// body must contain exactly one JSON value. If the body is malformed an Error
+// is returned; describing the issue and, where possible, the path of the
+// offending field.
+func DecodeJson(r *http.Request, v interface{}, opts DecodeOptions) error {
+	if !opts.AnyContentType && !isJsonContentType(r.Header.Get("Content-Type")) {
+		return Error{
+			Code:    ErrFailedConversionCode,
+			Message: "Content-Type must be application/json",
+		}
+	}
+	if r.Body == nil {
+		return Error{
+			Code:    ErrRequiredParamCode,
+			Message: "Request body is required",
+		}
+	}
+	maxBytes := opts.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBodyBytes
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBytes))
+	if opts.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return decodeError(err)
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return decodeError(err)
+		}
+		return Error{
+			Code:    ErrFailedConversionCode,
+			Message: "Request body must contain a single JSON value",
+		}
+	}
+	return nil
+}
+
+// decodeError maps a JSON decoding error to an Error.
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxBytesErr *http.MaxBytesError
+	var invalidErr *json.InvalidUnmarshalError
+	switch {
+	case errors.Is(err, io.EOF):
+		return Error{
+			Code:    ErrRequiredParamCode,
+			Message: "Request body is required",
+		}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return Error{
+			Code:    ErrFailedConversionCode,
+			Message: "Request body contains malformed JSON",
+		}
+	case errors.As(err, &syntaxErr):
+		return Error{
+			Code: ErrFailedConversionCode,
+			Message: fmt.Sprintf(
+				"Request body contains malformed JSON at offset %d",
+				syntaxErr.Offset,
+			),
+		}
+	case errors.As(err, &typeErr):
+		return Error{
+			Code:    ErrFailedConversionCode,
+			Message: "Request body contains an invalid value",
+			Details: []ErrorDetail{{
+				Field: typeErr.Field,
+				Message: fmt.Sprintf(
+					"expected %s but got %s",
+					typeErr.Type.String(), typeErr.Value,
+				),
+			}},
+		}
+	case errors.As(err, &maxBytesErr):
+		return Error{
+			Code: ErrFailedConversionCode,
+			Message: fmt.Sprintf(
+				"Request body must not be larger than %d bytes",
+				maxBytesErr.Limit,
+			),
+		}
+	case errors.As(err, &invalidErr):
+		return err
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return Error{
+			Code:    ErrFailedConversionCode,
+			Message: "Request body contains an unknown field",
+			Details: []ErrorDetail{{
+				Field:   strings.Trim(field, "\""),
+				Message: "unknown field",
+			}},
+		}
+	}
+	return Error{
+		Code:    ErrFailedConversionCode,
+		Message: fmt.Sprintf("Failed to decode request body; %s", err.Error()),
+	}
+}
+
+// isJsonContentType returns true if the content type is application/json or
+// a structured syntax suffixed type, e.g. application/merge-patch+json.
+func isJsonContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" ||
+		(strings.HasPrefix(mediaType, "application/") &&
+			strings.HasSuffix(mediaType, "+json"))
+}
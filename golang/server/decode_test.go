@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type decodeTestUser struct {
+	Name    string `json:"name"`
+	Address struct {
+		Zip int `json:"zip"`
+	} `json:"address"`
+}
+
+func newJsonRequest(t *testing.T, body string) *http.Request {
+	r, err := http.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	require.Nil(t, err)
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	return r
+}
+
+func TestDecodeJson(t *testing.T) {
+	var user decodeTestUser
+	r := newJsonRequest(t, `{"name":"alice","address":{"zip":12345}}`)
+	require.Nil(t, DecodeJson(r, &user, DecodeOptions{}))
+	require.Equal(t, "alice", user.Name)
+	require.Equal(t, 12345, user.Address.Zip)
+}
+
+func TestDecodeJsonErrors(t *testing.T) {
+	tests := []struct {
+		body    string
+		opts    DecodeOptions
+		code    int
+		field   string
+		message string
+	}{
+		{"", DecodeOptions{}, ErrRequiredParamCode, "", "Request body is required"},
+		{`{"name":`, DecodeOptions{}, ErrFailedConversionCode, "", "Request body contains malformed JSON"},
+		{`{"name" 1}`, DecodeOptions{}, ErrFailedConversionCode, "", "Request body contains malformed JSON at offset 9"},
+		{`{"address":{"zip":"abc"}}`, DecodeOptions{}, ErrFailedConversionCode, "address.zip", "Request body contains an invalid value"},
+		{`{"age":1}`, DecodeOptions{DisallowUnknownFields: true}, ErrFailedConversionCode, "age", "Request body contains an unknown field"},
+		{`{"name":"a"}{}`, DecodeOptions{}, ErrFailedConversionCode, "", "Request body must contain a single JSON value"},
+		{`{"name":"alice"}`, DecodeOptions{MaxBytes: 4}, ErrFailedConversionCode, "", "Request body must not be larger than 4 bytes"},
+	}
+	for _, test := range tests {
+		var user decodeTestUser
+		err := DecodeJson(newJsonRequest(t, test.body), &user, test.opts)
+		require.NotNil(t, err, test.body)
+		e, ok := err.(Error)
+		require.True(t, ok, test.body)
+		require.Equal(t, test.code, e.Code, test.body)
+		require.Equal(t, test.message, e.Message, test.body)
+		if test.field != "" {
+			require.Equal(t, test.field, e.Details[0].Field, test.body)
+		}
+	}
+}
+
+func TestDecodeJsonContentType(t *testing.T) {
+	var user decodeTestUser
+	r := newJsonRequest(t, `{"name":"alice"}`)
+	r.Header.Set("Content-Type", "text/plain")
+	err := DecodeJson(r, &user, DecodeOptions{})
+	require.NotNil(t, err)
+	require.Equal(t, ErrFailedConversionCode, err.(Error).Code)
+	require.Nil(t, DecodeJson(r, &user, DecodeOptions{AnyContentType: true}))
+}
+
+func TestIsJsonContentType(t *testing.T) {
+	require.True(t, isJsonContentType("application/json"))
+	require.True(t, isJsonContentType("application/merge-patch+json"))
+	require.False(t, isJsonContentType("text/json+xml"))
+	require.False(t, isJsonContentType(""))
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encoder represents an encoder of response data for a media type.
+type Encoder interface {
+	Encode(w io.Writer, data interface{}) error
+}
+
+// EncoderFunc is an adapter to allow the use of ordinary functions as
+// encoders.
+type EncoderFunc func(w io.Writer, data interface{}) error
+
+// Encode calls the encoder function.
+func (f EncoderFunc) Encode(w io.Writer, data interface{}) error {
+	return f(w, data)
+}
+
+// encoders represents the registered encoders in order of preference.
+var encoders = struct {
+	sync.RWMutex
+	types  []string           // media types in order of registration
+	byType map[string]Encoder // encoders keyed by media type
+}{byType: map[string]Encoder{}}
+
+func init() {
+	RegisterEncoder("application/json", EncoderFunc(encodeJson))
+	RegisterEncoder("application/xml", EncoderFunc(encodeXml))
+	RegisterEncoder("text/xml", EncoderFunc(encodeXml))
+	RegisterEncoder("application/cbor", EncoderFunc(encodeCbor))
+	RegisterEncoder("application/msgpack", EncoderFunc(encodeMsgpack))
+	RegisterEncoder("application/x-msgpack", EncoderFunc(encodeMsgpack))
+}
+
+// RegisterEncoder registers the encoder for the given media type; replacing
+// any existing encoder for it. When a request accepts several media types
+// equally, the earliest registered type is preferred.
+func RegisterEncoder(mediaType string, enc Encoder) {
+	mediaType = strings.ToLower(mediaType)
+	encoders.Lock()
+	defer encoders.Unlock()
+	if _, ok := encoders.byType[mediaType]; !ok {
+		encoders.types = append(encoders.types, mediaType)
+	}
+	encoders.byType[mediaType] = enc
+}
+
+// Respond encodes and writes a response using the given data object in the
+// media type that best matches the request's Accept header. If no registered
+// encoder is acceptable, a 406 Not Acceptable error is written as JSON. Errors
+// are handled as they are by JsonResponse; when problem details are enabled,
+// Errors encoded as JSON are written as application/problem+json.
+func Respond(w http.ResponseWriter, r *http.Request, data interface{}, status int) {
+	w.Header().Add("Vary", "Accept")
+	mediaType, enc := negotiate(r.Header.Get("Accept"))
+	if enc == nil {
+		JsonResponse(w, Error{
+			Code:    ErrNotAcceptableCode,
+			Message: "None of the accepted media types can be produced",
+		}, http.StatusNotAcceptable)
+		return
+	}
+	if errs, ok := data.(ValidationErrors); ok {
+		data = errs.Response()
+	}
+	contentType := mediaType
+	if mediaType == "application/json" && ProblemJsonEnabled() {
+		if e, ok := asError(data); ok {
+			data = e.Problem(status)
+			contentType = ProblemJsonContentType
+		}
+	}
+	data = withRequestId(w, data)
+	buf := &bytes.Buffer{}
+	if err := enc.Encode(buf, data); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// acceptedType represents a media range of an Accept header.
+type acceptedType struct {
+	mediaType string
+	quality   float64
+	order     int
+}
+
+// negotiate returns the registered media type and encoder best matching the
+// Accept header. An empty header accepts any media type.
+func negotiate(accept string) (string, Encoder) {
+	if strings.TrimSpace(accept) == "" {
+		accept = "*/*"
+	}
+	accepted := parseAccept(accept)
+	encoders.RLock()
+	defer encoders.RUnlock()
+	for _, a := range accepted {
+		if a.quality <= 0 {
+			continue
+		}
+		for _, t := range encoders.types {
+			if matchMediaType(a.mediaType, t) && !rejected(accepted, t) {
+				return t, encoders.byType[t]
+			}
+		}
+	}
+	return "", nil
+}
+
+// parseAccept parses the media ranges of an Accept header; ordered by quality
+// and specificity.
+func parseAccept(accept string) []acceptedType {
+	accepted := []acceptedType{}
+	for i, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		accepted = append(accepted, acceptedType{
+			mediaType: mediaType,
+			quality:   quality,
+			order:     i,
+		})
+	}
+	sort.SliceStable(accepted, func(i, j int) bool {
+		if accepted[i].quality != accepted[j].quality {
+			return accepted[i].quality > accepted[j].quality
+		}
+		return specificity(accepted[i].mediaType) >
+			specificity(accepted[j].mediaType)
+	})
+	return accepted
+}
+
+// specificity returns how specific a media range is; "*/*" being the least.
+func specificity(mediaRange string) int {
+	switch {
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*"):
+		return 1
+	}
+	return 2
+}
+
+// matchMediaType returns true if the media type is within the media range.
+func matchMediaType(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*"))
+	}
+	return false
+}
+
+// rejected returns true if the media type is explicitly not acceptable, i.e.
+// it is listed with a quality of zero.
+func rejected(accepted []acceptedType, mediaType string) bool {
+	for _, a := range accepted {
+		if a.mediaType == mediaType && a.quality <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeJson encodes the data as JSON, as it is written by JsonResponse.
+func encodeJson(w io.Writer, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// encodeXml encodes the data as XML.
+func encodeXml(w io.Writer, data interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(data)
+}
+
+// encodeCbor encodes the data as CBOR.
+func encodeCbor(w io.Writer, data interface{}) error {
+	return cbor.NewEncoder(w).Encode(data)
+}
+
+// encodeMsgpack encodes the data as MessagePack; using JSON struct tags.
+func encodeMsgpack(w io.Writer, data interface{}) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	return enc.Encode(data)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type encodingTestData struct {
+	XMLName xml.Name `json:"-" xml:"data"`
+	Name    string   `json:"name" xml:"name"`
+}
+
+func respond(t *testing.T, accept string, data interface{}) *httptest.ResponseRecorder {
+	r, err := http.NewRequest(http.MethodGet, "/hello", nil)
+	require.Nil(t, err)
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	rr := httptest.NewRecorder()
+	Respond(rr, r, data, http.StatusOK)
+	return rr
+}
+
+func TestRespond(t *testing.T) {
+	data := encodingTestData{Name: "alice"}
+
+	rr := respond(t, "", data)
+	require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	require.Equal(t, `{"name":"alice"}`, rr.Body.String())
+	require.Equal(t, "Accept", rr.Header().Get("Vary"))
+
+	rr = respond(t, "text/html, application/xml;q=0.9, */*;q=0.1", data)
+	require.Equal(t, "application/xml", rr.Header().Get("Content-Type"))
+	require.Equal(t, xml.Header+"<data><name>alice</name></data>", rr.Body.String())
+
+	rr = respond(t, "application/cbor", data)
+	require.Equal(t, "application/cbor", rr.Header().Get("Content-Type"))
+	actual := encodingTestData{}
+	require.Nil(t, cbor.Unmarshal(rr.Body.Bytes(), &actual))
+	require.Equal(t, "alice", actual.Name)
+
+	rr = respond(t, "application/msgpack", data)
+	require.Equal(t, "application/msgpack", rr.Header().Get("Content-Type"))
+	m := map[string]interface{}{}
+	require.Nil(t, msgpack.Unmarshal(rr.Body.Bytes(), &m))
+	require.Equal(t, "alice", m["name"])
+
+	rr = respond(t, "*/*, application/json;q=0", data)
+	require.Equal(t, "application/xml", rr.Header().Get("Content-Type"))
+}
+
+func TestRespondNotAcceptable(t *testing.T) {
+	rr := respond(t, "text/html", encodingTestData{Name: "alice"})
+	require.Equal(t, http.StatusNotAcceptable, rr.Code)
+	require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	require.Contains(t, rr.Body.String(), `"code":1008`)
+}
+
+func TestRespondError(t *testing.T) {
+	rr := httptest.NewRecorder()
+	rr.Header().Set(RequestIdHeader, "abc")
+	r, err := http.NewRequest(http.MethodGet, "/hello", nil)
+	require.Nil(t, err)
+	r.Header.Set("Accept", "application/xml")
+	Respond(rr, r, NewError(ErrNotFoundCode, "some message"), http.StatusNotFound)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	actual := errorBody{}
+	require.Nil(t, xml.Unmarshal(rr.Body.Bytes(), &actual))
+	require.Equal(t, ErrNotFoundCode, actual.Code)
+	require.Equal(t, "abc", actual.RequestId)
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	t.Cleanup(func() {
+		encoders.Lock()
+		defer encoders.Unlock()
+		delete(encoders.byType, "text/plain")
+		encoders.types = encoders.types[:len(encoders.types)-1]
+	})
+	RegisterEncoder("text/plain", EncoderFunc(func(w io.Writer, data interface{}) error {
+		_, err := io.WriteString(w, data.(encodingTestData).Name)
+		return err
+	}))
+	rr := respond(t, "text/plain", encodingTestData{Name: "alice"})
+	require.Equal(t, "text/plain", rr.Header().Get("Content-Type"))
+	require.Equal(t, "alice", rr.Body.String())
+}
+
+func TestRegisterEncoderJson(t *testing.T) {
+	t.Cleanup(func() {
+		RegisterEncoder("application/json", EncoderFunc(encodeJson))
+	})
+	RegisterEncoder("application/json", EncoderFunc(func(w io.Writer, data interface{}) error {
+		_, err := io.WriteString(w, "custom")
+		return err
+	}))
+	rr := respond(t, "application/json", encodingTestData{Name: "alice"})
+	require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	require.Equal(t, "custom", rr.Body.String())
+}
+
+func TestRespondProblemJson(t *testing.T) {
+	SetProblemJson(true)
+	defer SetProblemJson(false)
+	rr := respond(t, "application/json", NewError(ErrNotFoundCode, "some message"))
+	require.Equal(t, ProblemJsonContentType, rr.Header().Get("Content-Type"))
+	require.Contains(t, rr.Body.String(), `"type":"about:blank"`)
+}
+
+func TestParseAccept(t *testing.T) {
+	accepted := parseAccept("*/*;q=0.5, text/*, text/html, bad;;, application/json;q=0.5")
+	types := []string{}
+	for _, a := range accepted {
+		types = append(types, a.mediaType)
+	}
+	require.Equal(t, []string{"text/html", "text/*", "application/json", "*/*"}, types)
+}
+
+func TestMatchMediaType(t *testing.T) {
+	require.True(t, matchMediaType("*/*", "application/json"))
+	require.True(t, matchMediaType("application/*", "application/json"))
+	require.True(t, matchMediaType("application/json", "application/json"))
+	require.False(t, matchMediaType("text/*", "application/json"))
+}
+
+func TestEncodeJson(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.Nil(t, encodeJson(buf, encodingTestData{Name: "alice"}))
+	require.Equal(t, "{\"name\":\"alice\"}", buf.String())
+}
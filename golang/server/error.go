@@ -1,7 +1,10 @@
 package server
 
 import (
+	"encoding/xml"
 	"fmt"
+	"net/http"
+	"sync/atomic"
 )
 
 const (
@@ -12,15 +15,158 @@ const (
 	ErrRequiredParamCode
 	ErrUnauthorizedCode
 	ErrFailedConversionCode
+	ErrValidationCode
+	ErrNotAcceptableCode
+	ErrTooManyRequestsCode
+	ErrForbiddenCode
+	ErrPreconditionFailedCode
 )
 
-// Error represents a error response.
+// ProblemJsonContentType is the content type of problem details responses.
+const ProblemJsonContentType = "application/problem+json"
+
+// errorStatuses maps error codes to their canonical HTTP status.
+var errorStatuses = map[int]int{
+	ErrProcessingRequestCode:  http.StatusInternalServerError,
+	ErrNotFoundCode:           http.StatusNotFound,
+	ErrNotAllowedCode:         http.StatusMethodNotAllowed,
+	ErrServiceUnavailableCode: http.StatusServiceUnavailable,
+	ErrRequiredParamCode:      http.StatusBadRequest,
+	ErrUnauthorizedCode:       http.StatusUnauthorized,
+	ErrFailedConversionCode:   http.StatusBadRequest,
+	ErrValidationCode:         http.StatusUnprocessableEntity,
+	ErrNotAcceptableCode:      http.StatusNotAcceptable,
+	ErrTooManyRequestsCode:    http.StatusTooManyRequests,
+	ErrForbiddenCode:          http.StatusForbidden,
+	ErrPreconditionFailedCode: http.StatusPreconditionFailed,
+}
+
+// problemJson indicates whether errors are rendered as problem details.
+var problemJson int32
+
+// SetProblemJson enables or disables rendering errors written by JsonResponse
+// as RFC 7807 problem details (application/problem+json).
+func SetProblemJson(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&problemJson, v)
+}
+
+// ProblemJsonEnabled returns true if errors are rendered as problem details.
+func ProblemJsonEnabled() bool {
+	return atomic.LoadInt32(&problemJson) == 1
+}
+
+// Error represents a error response. The ID of the request being responded to
+// is included when the error is written.
 type Error struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	XMLName xml.Name      `json:"-" xml:"error"`
+	Code    int           `json:"code" xml:"code"`
+	Message string        `json:"message" xml:"message"`
+	Details []ErrorDetail `json:"details,omitempty" xml:"details>detail,omitempty"`
+	Err     error         `json:"-" xml:"-"` // underlying error; never exposed
+}
+
+// errorBody represents an Error as it is written in a response.
+type errorBody struct {
+	XMLName   xml.Name      `json:"-" xml:"error"`
+	Code      int           `json:"code" xml:"code"`
+	Message   string        `json:"message" xml:"message"`
+	RequestId string        `json:"request_id,omitempty" xml:"request_id,omitempty"`
+	Details   []ErrorDetail `json:"details,omitempty" xml:"details>detail,omitempty"`
+}
+
+// ErrorDetail represents the details of an error concerning a single field.
+type ErrorDetail struct {
+	Field   string `json:"field,omitempty" xml:"field,omitempty"`
+	Rule    string `json:"rule,omitempty" xml:"rule,omitempty"`
+	Message string `json:"message" xml:"message"`
+}
+
+// NewError returns a new error for the given code, message and details.
+func NewError(code int, message string, details ...ErrorDetail) Error {
+	return Error{Code: code, Message: message, Details: details}
+}
+
+// WrapError returns a new error for the given code and message wrapping the
+// underlying error. The underlying error is not included in responses.
+func WrapError(err error, code int, message string) Error {
+	return Error{Code: code, Message: message, Err: err}
 }
 
 // Error formats an error response as a string.
 func (e Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%d: %s; %s", e.Code, e.Message, e.Err.Error())
+	}
 	return fmt.Sprintf("%d: %s", e.Code, e.Message)
 }
+
+// Unwrap returns the underlying error.
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+// Status returns the canonical HTTP status of the error's code. Unknown codes
+// map to 500 Internal Server Error.
+func (e Error) Status() int {
+	if status, ok := errorStatuses[e.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// body returns the error as it is written in a response to the request with
+// the given ID.
+func (e Error) body(requestId string) errorBody {
+	return errorBody{
+		Code:      e.Code,
+		Message:   e.Message,
+		RequestId: requestId,
+		Details:   e.Details,
+	}
+}
+
+// Problem returns the error as RFC 7807 problem details for the given HTTP
+// status. If the status is zero, the error's canonical status is used.
+func (e Error) Problem(status int) Problem {
+	if status == 0 {
+		status = e.Status()
+	}
+	return Problem{
+		Type:    "about:blank",
+		Title:   http.StatusText(status),
+		Status:  status,
+		Detail:  e.Message,
+		Code:    e.Code,
+		Details: e.Details,
+	}
+}
+
+// Problem represents RFC 7807 problem details, extended with the error code,
+// request ID and details of an Error.
+type Problem struct {
+	Type      string        `json:"type"`
+	Title     string        `json:"title"`
+	Status    int           `json:"status"`
+	Detail    string        `json:"detail,omitempty"`
+	Instance  string        `json:"instance,omitempty"`
+	Code      int           `json:"code"`
+	RequestId string        `json:"request_id,omitempty"`
+	Details   []ErrorDetail `json:"details,omitempty"`
+}
+
+// asError returns the data as an Error if it is one.
+func asError(data interface{}) (Error, bool) {
+	switch e := data.(type) {
+	case Error:
+		return e, true
+	case *Error:
+		if e != nil {
+			return *e, true
+		}
+	}
+	return Error{}, false
+}
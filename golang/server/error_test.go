@@ -1,7 +1,11 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -11,6 +15,80 @@ func TestErrorString(t *testing.T) {
 	code := ErrRequiredParamCode
 	msg := "some message"
 	expected := fmt.Sprintf("%d: %s", code, msg)
-	actual := Error{code, msg}.Error()
+	actual := Error{Code: code, Message: msg}.Error()
 	require.Equal(t, expected, actual)
 }
+
+func TestErrorWrap(t *testing.T) {
+	underlying := errors.New("connection refused")
+	err := WrapError(underlying, ErrServiceUnavailableCode, "Service is unavailable")
+	require.Equal(t, underlying, errors.Unwrap(err))
+	require.True(t, errors.Is(err, underlying))
+	require.Equal(t, fmt.Sprintf(
+		"%d: Service is unavailable; connection refused",
+		ErrServiceUnavailableCode,
+	), err.Error())
+	var e Error
+	require.True(t, errors.As(fmt.Errorf("failed; %w", err), &e))
+	require.Equal(t, ErrServiceUnavailableCode, e.Code)
+}
+
+func TestErrorStatus(t *testing.T) {
+	require.Equal(t, http.StatusNotFound, NewError(ErrNotFoundCode, "").Status())
+	require.Equal(t, http.StatusUnauthorized, NewError(ErrUnauthorizedCode, "").Status())
+	require.Equal(t, http.StatusUnprocessableEntity, NewError(ErrValidationCode, "").Status())
+	require.Equal(t, http.StatusInternalServerError, NewError(1, "").Status())
+}
+
+func TestErrorProblem(t *testing.T) {
+	detail := ErrorDetail{Field: "name", Message: "is required"}
+	e := NewError(ErrRequiredParamCode, "some message", detail)
+	expected := Problem{
+		Type:    "about:blank",
+		Title:   "Bad Request",
+		Status:  http.StatusBadRequest,
+		Detail:  "some message",
+		Code:    ErrRequiredParamCode,
+		Details: []ErrorDetail{detail},
+	}
+	require.Equal(t, expected, e.Problem(0))
+	require.Equal(t, http.StatusConflict, e.Problem(http.StatusConflict).Status)
+}
+
+func TestProblemJson(t *testing.T) {
+	SetProblemJson(true)
+	defer SetProblemJson(false)
+	require.True(t, ProblemJsonEnabled())
+	rr := httptest.NewRecorder()
+	rr.Header().Set(RequestIdHeader, "abc")
+	JsonResponse(rr, NewError(ErrNotFoundCode, "some message"), http.StatusNotFound)
+	require.Equal(t, ProblemJsonContentType, rr.Header().Get("Content-Type"))
+	actual := Problem{}
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &actual))
+	require.Equal(t, "abc", actual.RequestId)
+	require.Equal(t, "Not Found", actual.Title)
+	require.Equal(t, http.StatusNotFound, actual.Status)
+	require.Equal(t, ErrNotFoundCode, actual.Code)
+
+	rr = httptest.NewRecorder()
+	JsonResponse(rr, map[string]string{"a": "b"}, http.StatusOK)
+	require.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+}
+
+func TestErrorResponse(t *testing.T) {
+	rr := httptest.NewRecorder()
+	ErrorResponse(rr, fmt.Errorf("wrapped; %w", NewError(ErrUnauthorizedCode, "no")))
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = httptest.NewRecorder()
+	ErrorResponse(rr, ValidationErrors{{"name", "required", "is required"}})
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	rr = httptest.NewRecorder()
+	ErrorResponse(rr, errors.New("secret"))
+	require.Equal(t, http.StatusInternalServerError, rr.Code)
+	actual := Error{}
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &actual))
+	require.Equal(t, ErrProcessingRequestCode, actual.Code)
+	require.Equal(t, "Failed to process request", actual.Message)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"io/fs"
+	"path"
+)
+
+// group implements the Router interface for a set of routes sharing a path
+// prefix, response settings, and middleware.
+type group struct {
+	srv        *server           // server the routes are added to
+	parent     *group            // parent group; nil for top-level groups
+	prefix     string            // path prefix
+	settings   []ResponseSetting // group response settings
+	middleware []Middleware      // group middleware
+	cors       *cors             // group CORS policy; nil to inherit
+}
+
+// newGroup returns a new group for the given server, parent group and path
+// prefix.
+func newGroup(s *server, parent *group, prefix string, options []RouteOption) *group {
+	cfg := newRouteConfig(options)
+	return &group{
+		srv:        s,
+		parent:     parent,
+		prefix:     prefix,
+		settings:   cfg.settings,
+		middleware: cfg.middleware,
+		cors:       cfg.cors,
+	}
+}
+
+// Add adds a new handler for the given method at the given path relative to
+// the group's prefix; applying all response settings to the response writer.
+func (g *group) Add(handler Handler, method, p string, settings ...ResponseSetting) error {
+	return g.Handle(handler, method, p, settingOptions(settings)...)
+}
+
+// Handle adds a new handler for the given method at the given path relative to
+// the group's prefix, configured by the given route options. The server's
+// middleware is executed first, followed by the middleware of each group from
+// the outermost to the innermost, and finally the route's middleware. Response
+// settings are applied in the same order.
+func (g *group) Handle(handler Handler, method, p string, options ...RouteOption) error {
+	return g.srv.handle(handler, method, g.path(p), g.config(options))
+}
+
+// config returns the configuration of a route added to the group with the
+// given options.
+func (g *group) config(options []RouteOption) routeConfig {
+	cfg := newRouteConfig(options)
+	settings := []ResponseSetting{}
+	middleware := append([]Middleware{}, g.srv.middleware...)
+	policy := g.srv.cors
+	for _, grp := range g.lineage() {
+		settings = append(settings, grp.settings...)
+		middleware = append(middleware, grp.middleware...)
+		if grp.cors != nil {
+			policy = grp.cors
+		}
+	}
+	cfg.settings = append(settings, cfg.settings...)
+	cfg.middleware = append(middleware, cfg.middleware...)
+	if cfg.cors == nil {
+		cfg.cors = policy
+	}
+	return cfg
+}
+
+// Static serves the files of the file system at the given path prefix relative
+// to the group's prefix; see Server.Static. Middleware and response settings
+// are applied as they are by Handle.
+func (g *group) Static(prefix string, fsys fs.FS, opts StaticOptions, options ...RouteOption) error {
+	return g.srv.static(g.path(prefix), fsys, opts, g.config(options))
+}
+
+// Use appends the given middleware to the group's middleware. Group middleware
+// is applied to all routes added to the group, and its subgroups, after the
+// call to Use.
+func (g *group) Use(middleware ...Middleware) {
+	g.middleware = append(g.middleware, middleware...)
+}
+
+// Group returns a new subgroup for the given path prefix relative to the
+// group's prefix.
+func (g *group) Group(prefix string, options ...RouteOption) Router {
+	return newGroup(g.srv, g, prefix, options)
+}
+
+// lineage returns the group and its ancestors, ordered from the outermost
+// group to the group itself.
+func (g *group) lineage() []*group {
+	groups := []*group{}
+	for grp := g; grp != nil; grp = grp.parent {
+		groups = append([]*group{grp}, groups...)
+	}
+	return groups
+}
+
+// path returns the full path for the given path relative to the group.
+func (g *group) path(p string) string {
+	full := "/"
+	for _, grp := range g.lineage() {
+		full = path.Join(full, grp.prefix)
+	}
+	return path.Join(full, p)
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupAdd(t *testing.T) {
+	order := []string{}
+	mw := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w http.ResponseWriter, r *http.Request, p Parameters) {
+				order = append(order, name)
+				next(w, r, p)
+			}
+		}
+	}
+	s := New(":8080", 10, 10).(*server)
+	s.run = 1
+	s.Use(mw("global"))
+	v1 := s.Group("/v1", mw("v1"), SetResponseHeader("version", "1"))
+	admin := v1.Group("admin", SetResponseHeader("scope", "admin"))
+	admin.Use(mw("admin"))
+	err := admin.Handle(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			order = append(order, "handler")
+			w.WriteHeader(http.StatusOK)
+		},
+		http.MethodGet, "/users/:id", mw("route"),
+	)
+	require.Nil(t, err)
+	r, err := http.NewRequest(http.MethodGet, "/v1/admin/users/abc", nil)
+	require.Nil(t, err)
+	rr := httptest.NewRecorder()
+	s.rtr.ServeHTTP(rr, r)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "1", rr.Header().Get("version"))
+	require.Equal(t, "admin", rr.Header().Get("scope"))
+	require.Equal(t, []string{"global", "v1", "admin", "route", "handler"}, order)
+}
+
+func TestGroupPath(t *testing.T) {
+	s := New(":8080", 10, 10).(*server)
+	v1 := newGroup(s, nil, "/v1/", nil)
+	require.Equal(t, "/v1/users", v1.path("/users/"))
+	require.Equal(t, "/v1", v1.path("/"))
+	nested := newGroup(s, v1, "admin", nil)
+	require.Equal(t, "/v1/admin/users/:id", nested.path("users/:id"))
+}
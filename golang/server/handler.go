@@ -7,6 +7,18 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
+// contextKey represents a key for values stored in a request's context.
+type contextKey int
+
+const (
+	clientIdentityKey contextKey = iota
+	routePatternKey
+	requestIdKey
+	claimsKey
+	apiKeyKey
+	routeRecorderKey
+)
+
 // Handler represents an HTTP handler method.
 type Handler func(http.ResponseWriter, *http.Request, Parameters)
 
@@ -40,6 +52,14 @@ func GetParameters(ctx context.Context) Parameters {
 	return parameters(httprouter.ParamsFromContext(ctx))
 }
 
+// GetRoutePattern returns the pattern of the route that matched the request,
+// e.g. "/users/:id". If the request was not routed, an empty string is
+// returned.
+func GetRoutePattern(ctx context.Context) string {
+	pattern, _ := ctx.Value(routePatternKey).(string)
+	return pattern
+}
+
 // Get returns a parmeter value for the given key. If a key does not exist an
 // empty string is returned.
 func (params Parameters) Get(key string) string {
@@ -80,6 +100,11 @@ func SetResponseHeader(key, value string) ResponseSetting {
 	}
 }
 
+// applyRoute appends the response setting to the route configuration.
+func (s ResponseSetting) applyRoute(cfg *routeConfig) {
+	cfg.settings = append(cfg.settings, s)
+}
+
 // applyResponseSettings applies all settings to a response writer.
 func applyResponseSettings(w http.ResponseWriter, settings []ResponseSetting) {
 	for _, s := range settings {
@@ -87,6 +112,48 @@ func applyResponseSettings(w http.ResponseWriter, settings []ResponseSetting) {
 	}
 }
 
+// RouteOption can be used to configure a route when it is added to a server
+// by Handle. ResponseSetting and Middleware are route options, as is the
+// option returned by Cors.
+type RouteOption interface {
+	applyRoute(cfg *routeConfig)
+}
+
+// settingOptions returns the response settings as route options.
+func settingOptions(settings []ResponseSetting) []RouteOption {
+	options := make([]RouteOption, len(settings))
+	for i, s := range settings {
+		options[i] = s
+	}
+	return options
+}
+
+// routeConfig represents the collected configuration of a route.
+type routeConfig struct {
+	settings   []ResponseSetting
+	middleware []Middleware
+	cors       *cors
+}
+
+// newRouteConfig returns the route configuration for the given options.
+func newRouteConfig(options []RouteOption) routeConfig {
+	cfg := routeConfig{}
+	for _, opt := range options {
+		opt.applyRoute(&cfg)
+	}
+	return cfg
+}
+
+// handler returns the handler wrapped by the route's middleware; the CORS
+// policy, if any, is applied before all middleware.
+func (cfg routeConfig) handler(h Handler) Handler {
+	h = chain(h, cfg.middleware)
+	if cfg.cors != nil {
+		h = cfg.cors.handler(h)
+	}
+	return h
+}
+
 // Route respresents a HTTP API route
 type Route struct {
 	Handler          Handler
@@ -96,3 +96,17 @@ func TestApplyResponseSettings(t *testing.T) {
 	applyResponseSettings(rr, []ResponseSetting{setting})
 	require.Equal(t, value, rr.Header().Get(key))
 }
+
+func TestNewRouteConfig(t *testing.T) {
+	mw := Middleware(func(next Handler) Handler { return next })
+	setting := SetResponseHeader("auth", "bearer")
+	cfg := newRouteConfig([]RouteOption{setting, mw})
+	require.Equal(t, 1, len(cfg.settings))
+	require.Equal(t, 1, len(cfg.middleware))
+}
+
+func TestGetRoutePattern(t *testing.T) {
+	require.Equal(t, "", GetRoutePattern(context.Background()))
+	ctx := context.WithValue(context.Background(), routePatternKey, "/users/:id")
+	require.Equal(t, "/users/:id", GetRoutePattern(ctx))
+}
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/crossedbot/common/golang/db"
+)
+
+const (
+	// DefaultLivenessPath is the default path of the liveness endpoint.
+	DefaultLivenessPath = "/healthz"
+
+	// DefaultReadinessPath is the default path of the readiness endpoint.
+	DefaultReadinessPath = "/readyz"
+
+	// DefaultHealthCheckTimeout is the default timeout of a health check.
+	DefaultHealthCheckTimeout = 5 * time.Second
+
+	// HealthStatusOk indicates a passing health check.
+	HealthStatusOk = "ok"
+
+	// HealthStatusFail indicates a failing health check.
+	HealthStatusFail = "fail"
+)
+
+// HealthCheck checks the health of a dependency; returning an error if it is
+// unhealthy.
+type HealthCheck func(ctx context.Context) error
+
+// HealthCheckOptions represents the configuration of a health check.
+type HealthCheckOptions struct {
+	// Timeout is the maximum duration of the check; defaults to
+	// DefaultHealthCheckTimeout.
+	Timeout time.Duration
+
+	// CacheTtl is how long the check's result is reused; zero runs the
+	// check on every request.
+	CacheTtl time.Duration
+
+	// Liveness indicates whether the check also affects liveness. All
+	// checks affect readiness.
+	Liveness bool
+}
+
+// HealthOptions represents the configuration of the health endpoints.
+type HealthOptions struct {
+	// LivenessPath is the path of the liveness endpoint; defaults to
+	// DefaultLivenessPath.
+	LivenessPath string
+
+	// ReadinessPath is the path of the readiness endpoint; defaults to
+	// DefaultReadinessPath.
+	ReadinessPath string
+
+	// DrainDelay is how long the server continues to serve requests, while
+	// the readiness endpoint reports that it is draining, before it begins
+	// to stop; giving load balancers time to stop routing requests to it.
+	DrainDelay time.Duration
+}
+
+// HealthResult represents the result of a single health check.
+type HealthResult struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	LatencyMs float64   `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// HealthReport represents the aggregated results of health checks.
+type HealthReport struct {
+	Status string                  `json:"status"`
+	Checks map[string]HealthResult `json:"checks,omitempty"`
+}
+
+// healthCheck represents a registered health check and its cached result.
+type healthCheck struct {
+	check   HealthCheck
+	opts    HealthCheckOptions
+	mu      sync.Mutex
+	result  *HealthResult
+	running *healthRun // in-flight run; nil if the check is not running
+}
+
+// healthRun represents a single run of a health check, shared by all callers
+// waiting on it.
+type healthRun struct {
+	done   chan struct{} // closed once the run has completed
+	result HealthResult
+}
+
+// Health represents a set of named health checks, and the readiness of the
+// server.
+type Health struct {
+	mu       sync.RWMutex
+	checks   map[string]*healthCheck
+	draining int32
+}
+
+// NewHealth returns a new, empty, set of health checks.
+func NewHealth() *Health {
+	return &Health{checks: map[string]*healthCheck{}}
+}
+
+// Add registers the named health check; replacing any existing check of the
+// same name.
+func (h *Health) Add(name string, check HealthCheck, opts HealthCheckOptions) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultHealthCheckTimeout
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = &healthCheck{check: check, opts: opts}
+}
+
+// Remove unregisters the named health check.
+func (h *Health) Remove(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.checks, name)
+}
+
+// SetDraining sets whether the server is draining; a draining server is not
+// ready.
+func (h *Health) SetDraining(draining bool) {
+	var v int32
+	if draining {
+		v = 1
+	}
+	atomic.StoreInt32(&h.draining, v)
+}
+
+// Draining returns true if the server is draining.
+func (h *Health) Draining() bool {
+	return atomic.LoadInt32(&h.draining) == 1
+}
+
+// Liveness runs the liveness checks; returning their report.
+func (h *Health) Liveness(ctx context.Context) HealthReport {
+	return h.run(ctx, true)
+}
+
+// Readiness runs all checks; returning their report. The report fails while
+// the server is draining.
+func (h *Health) Readiness(ctx context.Context) HealthReport {
+	report := h.run(ctx, false)
+	if h.Draining() {
+		report.Status = HealthStatusFail
+		report.Checks["draining"] = HealthResult{
+			Status:    HealthStatusFail,
+			Error:     "server is shutting down",
+			CheckedAt: time.Now(),
+		}
+	}
+	return report
+}
+
+// run runs the checks concurrently; only including liveness checks if
+// requested.
+func (h *Health) run(ctx context.Context, liveness bool) HealthReport {
+	h.mu.RLock()
+	names := []string{}
+	checks := []*healthCheck{}
+	for name, c := range h.checks {
+		if liveness && !c.opts.Liveness {
+			continue
+		}
+		names = append(names, name)
+		checks = append(checks, c)
+	}
+	h.mu.RUnlock()
+	results := make([]HealthResult, len(checks))
+	wg := sync.WaitGroup{}
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *healthCheck) {
+			defer wg.Done()
+			results[i] = c.run(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+	report := HealthReport{
+		Status: HealthStatusOk,
+		Checks: make(map[string]HealthResult, len(checks)),
+	}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != HealthStatusOk {
+			report.Status = HealthStatusFail
+		}
+	}
+	return report
+}
+
+// run returns the check's cached result, or the result of its next run.
+// Concurrent callers share a single run of the check; a caller whose context
+// expires first stops waiting for it.
+func (c *healthCheck) run(ctx context.Context) HealthResult {
+	c.mu.Lock()
+	if c.result != nil && time.Since(c.result.CheckedAt) < c.opts.CacheTtl {
+		result := *c.result
+		c.mu.Unlock()
+		return result
+	}
+	run := c.running
+	if run == nil {
+		run = &healthRun{done: make(chan struct{})}
+		c.running = run
+		go c.execute(run)
+	}
+	c.mu.Unlock()
+	select {
+	case <-run.done:
+		return run.result
+	case <-ctx.Done():
+		return HealthResult{
+			Status:    HealthStatusFail,
+			Error:     ctx.Err().Error(),
+			CheckedAt: time.Now(),
+		}
+	}
+}
+
+// execute runs the check within its timeout, recording the result of the run.
+// The check's context is independent of its callers', since they share it.
+func (c *healthCheck) execute(run *healthRun) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("check timed out after %s", c.opts.Timeout)
+	}
+	run.result = HealthResult{
+		Status:    HealthStatusOk,
+		LatencyMs: float64(time.Since(start)) / float64(time.Millisecond),
+		CheckedAt: start,
+	}
+	if err != nil {
+		run.result.Status = HealthStatusFail
+		run.result.Error = err.Error()
+	}
+	c.mu.Lock()
+	c.result = &run.result
+	c.running = nil
+	c.mu.Unlock()
+	close(run.done)
+}
+
+// handler returns a handler writing the report; with 503 Service Unavailable
+// if it failed.
+func (h *Health) handler(report func(ctx context.Context) HealthReport) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		rep := report(r.Context())
+		status := http.StatusOK
+		if rep.Status != HealthStatusOk {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		JsonResponse(w, rep, status)
+	}
+}
+
+// DatabaseCheck returns a health check pinging the database.
+func DatabaseCheck(d db.Database) HealthCheck {
+	return func(ctx context.Context) error {
+		return d.PingContext(ctx)
+	}
+}
+
+// Queue represents a queue of pending work, e.g. a taskmanager.Dispatcher or
+// taskmanager.Collector.
+type Queue interface {
+	QueueLength() int
+	QueueCapacity() int
+}
+
+// QueueCheck returns a health check failing when the queue's saturation, its
+// length relative to its capacity, exceeds the given maximum.
+func QueueCheck(q Queue, maxSaturation float64) HealthCheck {
+	return func(ctx context.Context) error {
+		capacity := q.QueueCapacity()
+		if capacity <= 0 {
+			return nil
+		}
+		saturation := float64(q.QueueLength()) / float64(capacity)
+		if saturation > maxSaturation {
+			return fmt.Errorf("queue saturation %.2f exceeds %.2f",
+				saturation, maxSaturation)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/crossedbot/common/golang/db"
+)
+
+type testQueue struct {
+	length   int
+	capacity int
+}
+
+func (q testQueue) QueueLength() int   { return q.length }
+func (q testQueue) QueueCapacity() int { return q.capacity }
+
+func getHealth(t *testing.T, s *server, path string) (int, HealthReport) {
+	r, err := http.NewRequest(http.MethodGet, path, nil)
+	require.Nil(t, err)
+	rr := httptest.NewRecorder()
+	s.handler().ServeHTTP(rr, r)
+	report := HealthReport{}
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	return rr.Code, report
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	s := New(":8080", 10, 10, WithHealth(HealthOptions{})).(*server)
+	s.Health().Add("alive", func(ctx context.Context) error {
+		return nil
+	}, HealthCheckOptions{Liveness: true})
+	s.Health().Add("dependency", func(ctx context.Context) error {
+		return errors.New("unreachable")
+	}, HealthCheckOptions{})
+
+	code, report := getHealth(t, s, DefaultLivenessPath)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, HealthStatusOk, report.Status)
+	require.Len(t, report.Checks, 1)
+
+	code, report = getHealth(t, s, DefaultReadinessPath)
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, HealthStatusFail, report.Status)
+	require.Equal(t, HealthStatusOk, report.Checks["alive"].Status)
+	require.Equal(t, HealthStatusFail, report.Checks["dependency"].Status)
+	require.Equal(t, "unreachable", report.Checks["dependency"].Error)
+}
+
+func TestHealthDraining(t *testing.T) {
+	s := New(":8080", 10, 10, WithHealth(HealthOptions{
+		ReadinessPath: "/ready",
+	})).(*server)
+	code, _ := getHealth(t, s, "/ready")
+	require.Equal(t, http.StatusOK, code)
+	s.Health().SetDraining(true)
+	code, report := getHealth(t, s, "/ready")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, HealthStatusFail, report.Checks["draining"].Status)
+	code, _ = getHealth(t, s, DefaultLivenessPath)
+	require.Equal(t, http.StatusOK, code)
+}
+
+func TestHealthDrainDelay(t *testing.T) {
+	addr := freeAddress(t)
+	s := New(addr, 10, 10, WithHealth(HealthOptions{
+		DrainDelay: 500 * time.Millisecond,
+	}))
+	require.Nil(t, s.Start())
+	ready := func() int {
+		resp, err := http.Get("http://" + addr + DefaultReadinessPath)
+		require.Nil(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusOK, ready())
+
+	stopped := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stopped <- s.Stop(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		return ready() == http.StatusServiceUnavailable
+	}, 250*time.Millisecond, 10*time.Millisecond)
+	require.Nil(t, <-stopped)
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	h := NewHealth()
+	h.Add("slow", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}, HealthCheckOptions{Timeout: 10 * time.Millisecond})
+	start := time.Now()
+	report := h.Readiness(context.Background())
+	require.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+	require.Equal(t, HealthStatusFail, report.Status)
+	require.Contains(t, report.Checks["slow"].Error, "timed out")
+}
+
+func TestHealthCheckCache(t *testing.T) {
+	calls := int32(0)
+	h := NewHealth()
+	h.Add("cached", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}, HealthCheckOptions{CacheTtl: time.Minute})
+	h.Readiness(context.Background())
+	h.Readiness(context.Background())
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestHealthCheckConcurrent(t *testing.T) {
+	calls := int32(0)
+	h := NewHealth()
+	h.Add("slow", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		<-ctx.Done()
+		return ctx.Err()
+	}, HealthCheckOptions{Timeout: 200 * time.Millisecond})
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report := h.Readiness(context.Background())
+			require.Equal(t, HealthStatusFail, report.Status)
+		}()
+	}
+	wg.Wait()
+	require.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestDatabaseCheck(t *testing.T) {
+	d := db.New("sqlite3")
+	require.Nil(t, d.Open(filepath.Join(t.TempDir(), "test.db")))
+	require.Nil(t, DatabaseCheck(d)(context.Background()))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, DatabaseCheck(d)(ctx), context.Canceled)
+	require.Nil(t, d.Close())
+	require.NotNil(t, DatabaseCheck(d)(context.Background()))
+}
+
+func TestQueueCheck(t *testing.T) {
+	ctx := context.Background()
+	require.Nil(t, QueueCheck(testQueue{length: 5, capacity: 10}, 0.8)(ctx))
+	require.NotNil(t, QueueCheck(testQueue{length: 9, capacity: 10}, 0.8)(ctx))
+	require.Nil(t, QueueCheck(testQueue{length: 9, capacity: 0}, 0.8)(ctx))
+}
@@ -0,0 +1,444 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Supported JWT signing algorithms.
+const (
+	JwtHS256 = "HS256"
+	JwtRS256 = "RS256"
+	JwtPS256 = "PS256"
+	JwtES256 = "ES256"
+	JwtEdDSA = "EdDSA"
+)
+
+var (
+	ErrJwtMalformed        = errors.New("token is malformed")
+	ErrJwtAlgorithm        = errors.New("token algorithm is not allowed")
+	ErrJwtUnknownKey       = errors.New("token key is unknown")
+	ErrJwtSignature        = errors.New("token signature is invalid")
+	ErrJwtExpired          = errors.New("token is expired")
+	ErrJwtNotYetValid      = errors.New("token is not yet valid")
+	ErrJwtInvalidIssuer    = errors.New("token issuer is invalid")
+	ErrJwtInvalidAudience  = errors.New("token audience is invalid")
+	ErrJwtMissingBearer    = errors.New("bearer token is missing")
+	ErrJwtUnsupportedKey   = errors.New("key type is not supported")
+	ErrJwtMissingKeys      = errors.New("no verification keys configured")
+	ErrJwtUnsupportedCurve = errors.New("key curve is not supported")
+)
+
+// Claims represents the claims of a JSON Web Token.
+type Claims map[string]interface{}
+
+// GetClaims returns the verified token claims stored in the context. If the
+// request was not authenticated by a token, nil is returned.
+func GetClaims(ctx context.Context) Claims {
+	claims, _ := ctx.Value(claimsKey).(Claims)
+	return claims
+}
+
+// String returns the value of a string claim. If the claim does not exist or
+// is not a string, an empty string is returned.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Subject returns the subject ("sub") claim.
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+// Issuer returns the issuer ("iss") claim.
+func (c Claims) Issuer() string {
+	return c.String("iss")
+}
+
+// Audience returns the audience ("aud") claim; which may either be a single
+// string or a list of strings.
+func (c Claims) Audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		audience := []string{}
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audience = append(audience, s)
+			}
+		}
+		return audience
+	}
+	return nil
+}
+
+// Time returns the value of a NumericDate claim, e.g. "exp". The boolean is
+// false if the claim does not exist or is not a number.
+func (c Claims) Time(name string) (time.Time, bool) {
+	switch v := c[name].(type) {
+	case float64:
+		sec, frac := int64(v), v-float64(int64(v))
+		return time.Unix(sec, int64(frac*float64(time.Second))), true
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		return Claims{name: f}.Time(name)
+	}
+	return time.Time{}, false
+}
+
+// JwtOptions represents the configuration of a JWT verifier.
+type JwtOptions struct {
+	// Keys is a map of verification keys by key ID ("kid"). Supported key
+	// types are []byte (HS256), *rsa.PublicKey (RS256, PS256),
+	// *ecdsa.PublicKey (ES256) and ed25519.PublicKey (EdDSA). A key with
+	// an empty ID verifies tokens without a key ID.
+	Keys map[string]interface{}
+
+	// JwksFile is the path to a local JSON Web Key Set file, whose keys
+	// are added to Keys.
+	JwksFile string
+
+	// Algorithms is the list of allowed algorithms; defaults to all
+	// supported algorithms.
+	Algorithms []string
+
+	// Issuer is the required issuer ("iss") claim, if not empty.
+	Issuer string
+
+	// Audience is the required audience ("aud") claim, if not empty.
+	Audience string
+
+	// ClockSkew is the tolerance applied when checking the expiration
+	// ("exp") and not before ("nbf") claims.
+	ClockSkew time.Duration
+}
+
+// JwtVerifier verifies JSON Web Tokens.
+type JwtVerifier struct {
+	opts JwtOptions
+	keys map[string]interface{}
+	now  func() time.Time
+}
+
+// NewJwtVerifier returns a new JWT verifier for the given options.
+func NewJwtVerifier(opts JwtOptions) (*JwtVerifier, error) {
+	keys := map[string]interface{}{}
+	for kid, key := range opts.Keys {
+		if _, err := keyAlgorithms(key); err != nil {
+			return nil, err
+		}
+		keys[kid] = key
+	}
+	if opts.JwksFile != "" {
+		jwks, err := loadJwks(opts.JwksFile)
+		if err != nil {
+			return nil, err
+		}
+		for kid, key := range jwks {
+			keys[kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, ErrJwtMissingKeys
+	}
+	if len(opts.Algorithms) == 0 {
+		opts.Algorithms = []string{
+			JwtHS256, JwtRS256, JwtPS256, JwtES256, JwtEdDSA,
+		}
+	}
+	return &JwtVerifier{opts: opts, keys: keys, now: time.Now}, nil
+}
+
+// Verify verifies the token's signature and its registered claims; returning
+// the token's claims.
+func (v *JwtVerifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJwtMalformed
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeJwtPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if !containsAny(v.opts.Algorithms, header.Alg) {
+		return nil, ErrJwtAlgorithm
+	}
+	key, ok := v.keys[header.Kid]
+	if !ok && header.Kid == "" && len(v.keys) == 1 {
+		for _, k := range v.keys {
+			key, ok = k, true
+		}
+	}
+	if !ok {
+		return nil, ErrJwtUnknownKey
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJwtMalformed
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	if err := verifyJwtSignature(header.Alg, key, signed, sig); err != nil {
+		return nil, err
+	}
+	claims := Claims{}
+	if err := decodeJwtPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := v.verifyClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verifyClaims checks the registered time, issuer and audience claims. A time
+// claim that is present but not a NumericDate makes the token malformed.
+func (v *JwtVerifier) verifyClaims(claims Claims) error {
+	for _, name := range []string{"exp", "nbf"} {
+		if _, ok := claims[name]; !ok {
+			continue
+		}
+		if _, ok := claims.Time(name); !ok {
+			return ErrJwtMalformed
+		}
+	}
+	now := v.now()
+	if exp, ok := claims.Time("exp"); ok && !now.Before(exp.Add(v.opts.ClockSkew)) {
+		return ErrJwtExpired
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Add(v.opts.ClockSkew).Before(nbf) {
+		return ErrJwtNotYetValid
+	}
+	if v.opts.Issuer != "" && claims.Issuer() != v.opts.Issuer {
+		return ErrJwtInvalidIssuer
+	}
+	if v.opts.Audience != "" &&
+		!containsAny(claims.Audience(), v.opts.Audience) {
+		return ErrJwtInvalidAudience
+	}
+	return nil
+}
+
+// JwtAuth returns a middleware that authenticates requests by the bearer
+// token in their Authorization header. Requests without a valid token are
+// rejected with 401 Unauthorized. The token's claims are available to
+// handlers via GetClaims.
+func JwtAuth(verifier *JwtVerifier) Middleware {
+	return func(next Handler) Handler {
+		return func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			token, err := bearerToken(r)
+			if err == nil {
+				var claims Claims
+				if claims, err = verifier.Verify(token); err == nil {
+					ctx := context.WithValue(r.Context(), claimsKey, claims)
+					next(w, r.WithContext(ctx), p)
+					return
+				}
+			}
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+				"Bearer error=\"invalid_token\", error_description=%q",
+				err.Error(),
+			))
+			JsonResponse(w, Error{
+				Code:    ErrUnauthorizedCode,
+				Message: "Unauthorized",
+			}, http.StatusUnauthorized)
+		}
+	}
+}
+
+// bearerToken returns the bearer token of the request's Authorization header.
+func bearerToken(r *http.Request) (string, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", ErrJwtMissingBearer
+	}
+	return token, nil
+}
+
+// decodeJwtPart decodes a base64url encoded JSON part of a token.
+func decodeJwtPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return ErrJwtMalformed
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return ErrJwtMalformed
+	}
+	return nil
+}
+
+// verifyJwtSignature verifies the signature of the signed bytes using the
+// algorithm and key.
+func verifyJwtSignature(alg string, key interface{}, signed, sig []byte) error {
+	algs, err := keyAlgorithms(key)
+	if err != nil || !containsAny(algs, alg) {
+		return ErrJwtAlgorithm
+	}
+	digest := sha256.Sum256(signed)
+	valid := false
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write(signed)
+		valid = hmac.Equal(sig, mac.Sum(nil))
+	case *rsa.PublicKey:
+		if alg == JwtPS256 {
+			valid = rsa.VerifyPSS(k, crypto.SHA256, digest[:], sig,
+				&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		} else {
+			valid = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+		}
+	case *ecdsa.PublicKey:
+		if len(sig) == 64 {
+			r := new(big.Int).SetBytes(sig[:32])
+			s := new(big.Int).SetBytes(sig[32:])
+			valid = ecdsa.Verify(k, digest[:], r, s)
+		}
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(k, signed, sig)
+	}
+	if !valid {
+		return ErrJwtSignature
+	}
+	return nil
+}
+
+// keyAlgorithms returns the algorithms that may be verified with the key.
+func keyAlgorithms(key interface{}) ([]string, error) {
+	switch k := key.(type) {
+	case []byte:
+		return []string{JwtHS256}, nil
+	case *rsa.PublicKey:
+		return []string{JwtRS256, JwtPS256}, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, ErrJwtUnsupportedCurve
+		}
+		return []string{JwtES256}, nil
+	case ed25519.PublicKey:
+		return []string{JwtEdDSA}, nil
+	}
+	return nil, ErrJwtUnsupportedKey
+}
+
+// jwk represents a JSON Web Key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// loadJwks returns the keys, by key ID, of a JSON Web Key Set file.
+func loadJwks(path string) (map[string]interface{}, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file; %s", err.Error())
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS file; %s", err.Error())
+	}
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWK %q; %s",
+				k.Kid, err.Error())
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+// publicKey returns the verification key of the JWK.
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "oct":
+		return decodeJwkParam(k.K)
+	case "RSA":
+		n, err := decodeJwkParam(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJwkParam(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, ErrJwtUnsupportedCurve
+		}
+		x, err := decodeJwkParam(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJwkParam(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, ErrJwtUnsupportedCurve
+		}
+		x, err := decodeJwkParam(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, ErrJwtUnsupportedKey
+}
+
+// decodeJwkParam decodes a base64url encoded, non-empty, JWK parameter.
+func decodeJwkParam(param string) ([]byte, error) {
+	if param == "" {
+		return nil, fmt.Errorf("missing key parameter")
+	}
+	return base64.RawURLEncoding.DecodeString(param)
+}
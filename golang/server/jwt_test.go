@@ -0,0 +1,246 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// signJwt returns a token for the claims signed with the algorithm and key.
+func signJwt(t *testing.T, alg, kid string, key interface{}, claims Claims) string {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, err := json.Marshal(header)
+	require.Nil(t, err)
+	c, err := json.Marshal(claims)
+	require.Nil(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." +
+		base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		if alg == JwtPS256 {
+			sig, err = rsa.SignPSS(rand.Reader, k, crypto.SHA256, digest[:],
+				&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		}
+		require.Nil(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.Nil(t, err)
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJwtVerifierAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	secret := []byte("secret")
+	v, err := NewJwtVerifier(JwtOptions{Keys: map[string]interface{}{
+		"hs": secret,
+		"rs": &rsaKey.PublicKey,
+		"es": &ecKey.PublicKey,
+		"ed": edPub,
+	}})
+	require.Nil(t, err)
+	claims := Claims{"sub": "alice"}
+	tests := []struct {
+		alg string
+		kid string
+		key interface{}
+	}{
+		{JwtHS256, "hs", secret},
+		{JwtRS256, "rs", rsaKey},
+		{JwtPS256, "rs", rsaKey},
+		{JwtES256, "es", ecKey},
+		{JwtEdDSA, "ed", edKey},
+	}
+	for _, test := range tests {
+		actual, err := v.Verify(signJwt(t, test.alg, test.kid, test.key, claims))
+		require.Nil(t, err, test.alg)
+		require.Equal(t, "alice", actual.Subject(), test.alg)
+	}
+
+	// Algorithm confusion and unknown keys are rejected
+	_, err = v.Verify(signJwt(t, JwtHS256, "rs", secret, claims))
+	require.Equal(t, ErrJwtAlgorithm, err)
+	_, err = v.Verify(signJwt(t, JwtHS256, "unknown", secret, claims))
+	require.Equal(t, ErrJwtUnknownKey, err)
+	_, err = v.Verify(signJwt(t, "none", "hs", secret, claims))
+	require.Equal(t, ErrJwtAlgorithm, err)
+	_, err = v.Verify(signJwt(t, JwtHS256, "hs", []byte("other"), claims))
+	require.Equal(t, ErrJwtSignature, err)
+	_, err = v.Verify("abc")
+	require.Equal(t, ErrJwtMalformed, err)
+}
+
+func TestJwtVerifierClaims(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	v, err := NewJwtVerifier(JwtOptions{
+		Keys:      map[string]interface{}{"": secret},
+		Issuer:    "issuer",
+		Audience:  "api",
+		ClockSkew: time.Minute,
+	})
+	require.Nil(t, err)
+	v.now = func() time.Time { return now }
+	valid := func() Claims {
+		return Claims{
+			"iss": "issuer",
+			"aud": []string{"web", "api"},
+			"exp": now.Add(-30 * time.Second).Unix(),
+			"nbf": now.Add(30 * time.Second).Unix(),
+		}
+	}
+	_, err = v.Verify(signJwt(t, JwtHS256, "", secret, valid()))
+	require.Nil(t, err)
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected error
+	}{
+		{"exp", now.Add(-2 * time.Minute).Unix(), ErrJwtExpired},
+		{"nbf", now.Add(2 * time.Minute).Unix(), ErrJwtNotYetValid},
+		{"exp", "1", ErrJwtMalformed},
+		{"nbf", nil, ErrJwtMalformed},
+		{"iss", "other", ErrJwtInvalidIssuer},
+		{"aud", "web", ErrJwtInvalidAudience},
+	}
+	for _, test := range tests {
+		claims := valid()
+		claims[test.name] = test.value
+		_, err = v.Verify(signJwt(t, JwtHS256, "", secret, claims))
+		require.Equal(t, test.expected, err, test.name)
+	}
+}
+
+func TestJwtVerifierJwks(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks := map[string]interface{}{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hs", "k": b64([]byte("secret"))},
+		{
+			"kty": "RSA", "kid": "rs",
+			"n": b64(rsaKey.N.Bytes()),
+			"e": b64([]byte{1, 0, 1}),
+		},
+		{
+			"kty": "EC", "kid": "es", "crv": "P-256",
+			"x": b64(ecKey.X.Bytes()),
+			"y": b64(ecKey.Y.Bytes()),
+		},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(edPub)},
+	}}
+	b, err := json.Marshal(jwks)
+	require.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.Nil(t, os.WriteFile(path, b, 0600))
+	v, err := NewJwtVerifier(JwtOptions{JwksFile: path})
+	require.Nil(t, err)
+	claims := Claims{"sub": "alice"}
+	_, err = v.Verify(signJwt(t, JwtHS256, "hs", []byte("secret"), claims))
+	require.Nil(t, err)
+	_, err = v.Verify(signJwt(t, JwtRS256, "rs", rsaKey, claims))
+	require.Nil(t, err)
+	_, err = v.Verify(signJwt(t, JwtES256, "es", ecKey, claims))
+	require.Nil(t, err)
+	_, err = v.Verify(signJwt(t, JwtEdDSA, "ed", edKey, claims))
+	require.Nil(t, err)
+
+	_, err = NewJwtVerifier(JwtOptions{JwksFile: filepath.Join(t.TempDir(), "missing")})
+	require.NotNil(t, err)
+	_, err = NewJwtVerifier(JwtOptions{})
+	require.Equal(t, ErrJwtMissingKeys, err)
+}
+
+func TestJwtAuth(t *testing.T) {
+	secret := []byte("secret")
+	v, err := NewJwtVerifier(JwtOptions{Keys: map[string]interface{}{"": secret}})
+	require.Nil(t, err)
+	s := New(":8080", 10, 10).(*server)
+	s.run = 1
+	require.Nil(t, s.Handle(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(GetClaims(r.Context()).Subject()))
+		},
+		http.MethodGet, "/hello", JwtAuth(v),
+	))
+	request := func(auth string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(http.MethodGet, "/hello", nil)
+		require.Nil(t, err)
+		r.Header.Set("Authorization", auth)
+		rr := httptest.NewRecorder()
+		s.handler().ServeHTTP(rr, r)
+		return rr
+	}
+	token := signJwt(t, JwtHS256, "", secret, Claims{"sub": "alice"})
+	rr := request("Bearer " + token)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "alice", rr.Body.String())
+	rr = request("Basic abc")
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+	require.Contains(t, rr.Header().Get("WWW-Authenticate"), "invalid_token")
+	require.Contains(t, rr.Body.String(), `"code":1005`)
+	require.Equal(t, http.StatusUnauthorized, request("Bearer abc").Code)
+}
+
+func TestClaims(t *testing.T) {
+	claims := Claims{
+		"sub": "alice",
+		"iss": "issuer",
+		"aud": "api",
+		"exp": 1.5,
+		"nbf": json.Number("2"),
+	}
+	require.Equal(t, "alice", claims.Subject())
+	require.Equal(t, "issuer", claims.Issuer())
+	require.Equal(t, []string{"api"}, claims.Audience())
+	exp, ok := claims.Time("exp")
+	require.True(t, ok)
+	require.Equal(t, time.Unix(1, int64(time.Second/2)), exp)
+	nbf, ok := claims.Time("nbf")
+	require.True(t, ok)
+	require.Equal(t, time.Unix(2, 0), nbf)
+	_, ok = claims.Time("sub")
+	require.False(t, ok)
+}
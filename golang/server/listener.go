@@ -0,0 +1,313 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ListenPidEnv is the environment variable containing the PID of the
+	// process systemd passed listening sockets to.
+	ListenPidEnv = "LISTEN_PID"
+
+	// ListenFdsEnv is the environment variable containing the number of
+	// listening sockets passed by systemd.
+	ListenFdsEnv = "LISTEN_FDS"
+
+	// ListenFdNamesEnv is the environment variable containing the
+	// colon-separated names of the passed listening sockets.
+	ListenFdNamesEnv = "LISTEN_FDNAMES"
+
+	// UpgradeFdsEnv is the environment variable containing the number of
+	// listening sockets passed to a child process by Upgrade.
+	UpgradeFdsEnv = "UPGRADE_LISTEN_FDS"
+
+	// listenFdsStart is the first passed file descriptor; following stdin,
+	// stdout and stderr.
+	listenFdsStart = 3
+)
+
+// ListenerConfig represents a listener of a server.
+type ListenerConfig struct {
+	// Network is the network of the listener; either "tcp" (the default),
+	// "tcp4", "tcp6" or "unix".
+	Network string
+
+	// Address is the host and port of a TCP listener, or the socket path of
+	// a Unix listener.
+	Address string
+
+	// Tls indicates whether the listener serves HTTPS using the server's TLS
+	// configuration and certificate. The server's own address serves HTTPS
+	// only if TLS is enabled for the server.
+	Tls bool
+
+	// Mode is the file mode of a Unix socket; zero leaves the mode set by
+	// the umask.
+	Mode os.FileMode
+}
+
+// socket represents a duplicated listening socket of a server.
+type socket struct {
+	file *os.File // duplicate of the socket
+	tls  bool     // indicates whether the socket serves tls
+	path string   // unix socket path removed when the server stops
+}
+
+// unix returns true if the listener is a Unix listener.
+func (cfg ListenerConfig) unix() bool {
+	return cfg.Network == "unix"
+}
+
+// path returns the socket path of a Unix listener.
+func (cfg ListenerConfig) path() string {
+	if cfg.unix() {
+		return cfg.Address
+	}
+	return ""
+}
+
+// listen binds the listener's address. A stale Unix socket, one that is not
+// accepting connections, is removed before binding its path.
+func (cfg ListenerConfig) listen() (net.Listener, error) {
+	switch cfg.Network {
+	case "", "tcp", "tcp4", "tcp6":
+	case "unix":
+		return cfg.listenUnix()
+	default:
+		return nil, fmt.Errorf("unsupported network %q", cfg.Network)
+	}
+	network := cfg.Network
+	if network == "" {
+		network = "tcp"
+	}
+	l, err := net.Listen(network, cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to create listener; %s",
+			err.Error(),
+		)
+	}
+	return l, nil
+}
+
+// listenUnix binds the Unix socket path and sets its file mode.
+func (cfg ListenerConfig) listenUnix() (net.Listener, error) {
+	if fi, err := os.Stat(cfg.Address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", cfg.Address); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf(
+				"failed to create listener; %s is in use",
+				cfg.Address,
+			)
+		}
+		os.Remove(cfg.Address)
+	}
+	l, err := net.Listen("unix", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"failed to create listener; %s",
+			err.Error(),
+		)
+	}
+	// The socket is removed when the server stops, rather than when the
+	// listener closes, so that it persists across restarts
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if cfg.Mode != 0 {
+		if err := os.Chmod(cfg.Address, cfg.Mode); err != nil {
+			l.Close()
+			os.Remove(cfg.Address)
+			return nil, fmt.Errorf(
+				"failed to set socket mode; %s",
+				err.Error(),
+			)
+		}
+	}
+	return l, nil
+}
+
+// matchListener returns the index of the listener bound to the configuration's
+// address, or -1 if there is none.
+func matchListener(listeners []net.Listener, cfg ListenerConfig) int {
+	for i, l := range listeners {
+		addr := l.Addr()
+		if cfg.unix() {
+			if addr.Network() == "unix" && addr.String() == cfg.Address {
+				return i
+			}
+			continue
+		}
+		tcpAddr, ok := addr.(*net.TCPAddr)
+		if !ok {
+			continue
+		}
+		want, err := net.ResolveTCPAddr("tcp", cfg.Address)
+		if err != nil || want.Port != tcpAddr.Port {
+			continue
+		}
+		if want.IP == nil || want.IP.IsUnspecified() {
+			if tcpAddr.IP.IsUnspecified() {
+				return i
+			}
+		} else if want.IP.Equal(tcpAddr.IP) {
+			return i
+		}
+	}
+	return -1
+}
+
+// filer is implemented by listeners whose socket can be duplicated, e.g.
+// *net.TCPListener and *net.UnixListener.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// InheritedListeners returns the listening sockets passed to the process,
+// either by systemd socket activation (LISTEN_FDS and LISTEN_PID) or by the
+// parent process of a graceful upgrade (UPGRADE_LISTEN_FDS). The environment
+// variables are unset so that they are not inherited by child processes. If no
+// sockets were passed, no listeners are returned.
+func InheritedListeners() ([]net.Listener, error) {
+	return inheritedListeners(listenFdsStart)
+}
+
+// inheritedListeners returns the passed listening sockets starting from the
+// given file descriptor.
+func inheritedListeners(start int) ([]net.Listener, error) {
+	defer func() {
+		os.Unsetenv(ListenPidEnv)
+		os.Unsetenv(ListenFdsEnv)
+		os.Unsetenv(ListenFdNamesEnv)
+		os.Unsetenv(UpgradeFdsEnv)
+	}()
+	count := os.Getenv(UpgradeFdsEnv)
+	if count == "" {
+		pid, err := strconv.Atoi(os.Getenv(ListenPidEnv))
+		if err != nil || pid != os.Getpid() {
+			return nil, nil
+		}
+		count = os.Getenv(ListenFdsEnv)
+	}
+	if count == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid number of listening sockets %q", count)
+	}
+	names := strings.Split(os.Getenv(ListenFdNamesEnv), ":")
+	listeners := make([]net.Listener, 0, n)
+	for fd := start; fd < start+n; fd++ {
+		name := fmt.Sprintf("fd%d", fd)
+		if i := fd - start; i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		// The listener duplicates the descriptor with close-on-exec set
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf(
+				"failed to inherit listening socket %s; %s",
+				name, err.Error(),
+			)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// listenerFiles returns duplicates of the listeners' sockets.
+func listenerFiles(listeners []net.Listener) ([]*os.File, error) {
+	files := make([]*os.File, 0, len(listeners))
+	for _, l := range listeners {
+		fl, ok := l.(filer)
+		if !ok {
+			closeFiles(files)
+			return nil, fmt.Errorf(
+				"listener %s does not support file duplication",
+				l.Addr(),
+			)
+		}
+		f, err := fl.File()
+		if err != nil {
+			closeFiles(files)
+			return nil, fmt.Errorf(
+				"failed to duplicate listener %s; %s",
+				l.Addr(), err.Error(),
+			)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// fileListeners returns new listeners for the given sockets; leaving the files
+// open.
+func fileListeners(files []*os.File) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(files))
+	for _, f := range files {
+		l, err := net.FileListener(f)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf(
+				"failed to create listener from %s; %s",
+				f.Name(), err.Error(),
+			)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// closeListeners closes all listeners.
+func closeListeners(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
+
+// closeFiles closes all files.
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// upgrade starts the executable with the given arguments, passing it the
+// listening sockets.
+func upgrade(path string, args []string, files []*os.File) (*os.Process, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("server is not listening")
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Env = append(
+		environ(ListenPidEnv, ListenFdsEnv, ListenFdNamesEnv, UpgradeFdsEnv),
+		fmt.Sprintf("%s=%d", UpgradeFdsEnv, len(files)),
+	)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start upgrade; %s", err.Error())
+	}
+	return cmd.Process, nil
+}
+
+// environ returns the process's environment without the given variables.
+func environ(exclude ...string) []string {
+	env := []string{}
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if !containsAny(exclude, name) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
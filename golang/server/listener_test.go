@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInheritedListeners(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	require.Nil(t, err)
+	// The inherited descriptor is owned, and closed, by inheritedListeners
+	fd, err := syscall.Dup(int(f.Fd()))
+	require.Nil(t, err)
+	f.Close()
+
+	t.Setenv(ListenPidEnv, strconv.Itoa(os.Getpid()+1))
+	t.Setenv(ListenFdsEnv, "1")
+	listeners, err := inheritedListeners(fd)
+	require.Nil(t, err)
+	require.Empty(t, listeners)
+
+	t.Setenv(ListenPidEnv, strconv.Itoa(os.Getpid()))
+	t.Setenv(ListenFdsEnv, "1")
+	t.Setenv(ListenFdNamesEnv, "web")
+	listeners, err = inheritedListeners(fd)
+	require.Nil(t, err)
+	require.Len(t, listeners, 1)
+	defer listeners[0].Close()
+	require.Equal(t, l.Addr().String(), listeners[0].Addr().String())
+	_, ok := os.LookupEnv(ListenFdsEnv)
+	require.False(t, ok)
+
+	t.Setenv(UpgradeFdsEnv, "x")
+	_, err = inheritedListeners(fd)
+	require.NotNil(t, err)
+}
+
+func TestServerWithListeners(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	addr := l.Addr().String()
+	s := New("", 10, 10, WithListeners(l)).(*server)
+	require.Nil(t, s.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			w.WriteHeader(http.StatusOK)
+		},
+		http.MethodGet, "/hello",
+	))
+	require.Nil(t, s.Start())
+	get := func() int {
+		resp, err := http.Get("http://" + addr + "/hello")
+		require.Nil(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusOK, get())
+
+	// The socket remains open, queuing connections, between restarts
+	require.Nil(t, s.stop(context.Background()))
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	require.Nil(t, s.Start())
+	_, err = io.WriteString(conn, "GET /hello HTTP/1.0\r\n\r\n")
+	require.Nil(t, err)
+	b, err := io.ReadAll(conn)
+	require.Nil(t, err)
+	conn.Close()
+	require.Contains(t, string(b), "200 OK")
+	require.Nil(t, s.Reload())
+	require.Equal(t, http.StatusOK, get())
+
+	require.Nil(t, s.Stop(context.Background()))
+	_, err = net.Dial("tcp", addr)
+	require.NotNil(t, err)
+}
+
+func TestUpgrade(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	addr := l.Addr().String()
+	files, err := listenerFiles([]net.Listener{l})
+	require.Nil(t, err)
+	l.Close()
+
+	t.Setenv("UPGRADE_HELPER_PROCESS", "1")
+	proc, err := upgrade(
+		os.Args[0], []string{"-test.run=TestUpgradeHelperProcess"}, files,
+	)
+	require.Nil(t, err)
+	defer proc.Kill()
+	closeFiles(files)
+
+	// The child serves from the inherited socket
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + addr + "/hello")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.Equal(t, strconv.Itoa(proc.Pid), string(b))
+}
+
+// TestUpgradeHelperProcess is the child process started by TestUpgrade.
+func TestUpgradeHelperProcess(t *testing.T) {
+	if os.Getenv("UPGRADE_HELPER_PROCESS") != "1" {
+		t.Skip("helper process")
+	}
+	listeners, err := InheritedListeners()
+	require.Nil(t, err)
+	require.Len(t, listeners, 1)
+	done := make(chan struct{})
+	s := New("", 10, 10, WithListeners(listeners...))
+	require.Nil(t, s.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			io.WriteString(w, strconv.Itoa(os.Getpid()))
+			close(done)
+		},
+		http.MethodGet, "/hello",
+	))
+	require.Nil(t, s.Start())
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+	}
+	s.Stop(context.Background())
+}
+
+func TestServerMultipleListeners(t *testing.T) {
+	certFile, keyFile := writeCertificate(t, t.TempDir(), "localhost")
+	addr := freeAddress(t)
+	tlsAddr := freeAddress(t)
+	sock := filepath.Join(t.TempDir(), "server.sock")
+	s := New(addr, 10, 10,
+		WithListener(ListenerConfig{Address: tlsAddr, Tls: true}),
+		WithListener(ListenerConfig{Network: "unix", Address: sock, Mode: 0600}),
+	)
+	require.Nil(t, s.SetTlsCertificate(certFile, keyFile, 0))
+	s.SetTlsConfiguration(false, nil)
+	require.Nil(t, s.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			io.WriteString(w, strconv.FormatBool(r.TLS != nil))
+		},
+		http.MethodGet, "/hello",
+	))
+	require.Nil(t, s.Start())
+
+	fi, err := os.Stat(sock)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	get := func(client *http.Client, url string) string {
+		resp, err := client.Get(url)
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.Nil(t, err)
+		return string(b)
+	}
+	tlsClient := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	check := func() {
+		require.Equal(t, "false", get(http.DefaultClient, "http://"+addr+"/hello"))
+		require.Equal(t, "true", get(tlsClient, "https://"+tlsAddr+"/hello"))
+		require.Equal(t, "false", get(unixClient, "http://unix/hello"))
+	}
+	check()
+	require.Nil(t, s.Reload())
+	check()
+
+	require.Nil(t, s.Stop(context.Background()))
+	_, err = os.Stat(sock)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestListenerConfigUnix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "server.sock")
+	cfg := ListenerConfig{Network: "unix", Address: sock}
+	l, err := cfg.listen()
+	require.Nil(t, err)
+
+	// A socket accepting connections is in use
+	_, err = cfg.listen()
+	require.NotNil(t, err)
+
+	// A stale socket is replaced
+	l.Close()
+	_, err = os.Stat(sock)
+	require.Nil(t, err)
+	l, err = cfg.listen()
+	require.Nil(t, err)
+	l.Close()
+
+	_, err = ListenerConfig{Network: "udp", Address: ":0"}.listen()
+	require.NotNil(t, err)
+}
+
+func TestMatchListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	wildcard, err := net.Listen("tcp", ":0")
+	require.Nil(t, err)
+	defer wildcard.Close()
+	wildcardPort := strconv.Itoa(wildcard.Addr().(*net.TCPAddr).Port)
+	listeners := []net.Listener{l, wildcard}
+
+	require.Equal(t, 0, matchListener(listeners, ListenerConfig{Address: "127.0.0.1:" + port}))
+	require.Equal(t, -1, matchListener(listeners, ListenerConfig{Address: ":" + port}))
+	require.Equal(t, 1, matchListener(listeners, ListenerConfig{Address: ":" + wildcardPort}))
+	require.Equal(t, -1, matchListener(listeners, ListenerConfig{
+		Network: "unix", Address: "127.0.0.1:" + port,
+	}))
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/crossedbot/common/golang/metrics"
+)
+
+// DefaultMetricsPath is the default path of the metrics endpoint.
+const DefaultMetricsPath = "/metrics"
+
+// DefaultSizeBuckets are the default response size buckets in bytes.
+var DefaultSizeBuckets = []float64{
+	100, 1000, 10000, 100000, 1000000, 10000000,
+}
+
+// MetricsOptions represents the configuration of the server's metrics.
+type MetricsOptions struct {
+	// Path is the path of the metrics endpoint; defaults to
+	// DefaultMetricsPath.
+	Path string
+
+	// Registry is the registry the metrics are registered into and exposed
+	// from; defaults to a new registry for the server. Servers sharing a
+	// registry share the metrics registered by the first of them; a
+	// metric already registered with another type is not exposed.
+	Registry *metrics.Registry
+
+	// Namespace is prefixed to the names of the metrics, e.g.
+	// "<namespace>_http_requests_total".
+	Namespace string
+
+	// LatencyBuckets are the request duration buckets in seconds; defaults
+	// to metrics.DefaultBuckets.
+	LatencyBuckets []float64
+
+	// SizeBuckets are the response size buckets in bytes; defaults to
+	// DefaultSizeBuckets.
+	SizeBuckets []float64
+}
+
+// serverMetrics represents the metrics recorded by the server.
+type serverMetrics struct {
+	requests *metrics.Counter
+	latency  *metrics.Histogram
+	size     *metrics.Histogram
+	inFlight *metrics.Gauge
+}
+
+// newServerMetrics returns the server's metrics registered into the registry;
+// reusing metrics of the same name that are already registered.
+func newServerMetrics(opts MetricsOptions) *serverMetrics {
+	prefix := "http_"
+	if opts.Namespace != "" {
+		prefix = opts.Namespace + "_" + prefix
+	}
+	if len(opts.SizeBuckets) == 0 {
+		opts.SizeBuckets = DefaultSizeBuckets
+	}
+	m := &serverMetrics{
+		requests: metrics.NewCounter(
+			prefix+"requests_total",
+			"Total number of HTTP requests.",
+			"route", "method", "status",
+		),
+		latency: metrics.NewHistogram(
+			prefix+"request_duration_seconds",
+			"Duration of HTTP requests in seconds.",
+			opts.LatencyBuckets,
+			"route", "method", "status",
+		),
+		size: metrics.NewHistogram(
+			prefix+"response_size_bytes",
+			"Size of HTTP response bodies in bytes.",
+			opts.SizeBuckets,
+			"route", "method", "status",
+		),
+		inFlight: metrics.NewGauge(
+			prefix+"requests_in_flight",
+			"Number of HTTP requests currently being served.",
+		),
+	}
+	if c, ok := opts.Registry.GetOrRegister(m.requests).(*metrics.Counter); ok {
+		m.requests = c
+	}
+	if h, ok := opts.Registry.GetOrRegister(m.latency).(*metrics.Histogram); ok {
+		m.latency = h
+	}
+	if h, ok := opts.Registry.GetOrRegister(m.size).(*metrics.Histogram); ok {
+		m.size = h
+	}
+	if g, ok := opts.Registry.GetOrRegister(m.inFlight).(*metrics.Gauge); ok {
+		m.inFlight = g
+	}
+	return m
+}
+
+// observe records the completed request.
+func (m *serverMetrics) observe(route, method string, w *responseWriter, start time.Time) {
+	status := strconv.Itoa(w.Status())
+	m.requests.Inc(route, method, status)
+	m.latency.Observe(time.Since(start).Seconds(), route, method, status)
+	m.size.Observe(float64(w.Size()), route, method, status)
+}
+
+// WithMetrics records request counts, latencies, response sizes and in-flight
+// requests for all routes, labelled by route pattern, method and status, and
+// exposes the registry in the Prometheus text format. The endpoint bypasses
+// all middleware.
+func WithMetrics(opts MetricsOptions) Option {
+	return func(s *server) {
+		if opts.Path == "" {
+			opts.Path = DefaultMetricsPath
+		}
+		if opts.Registry == nil {
+			opts.Registry = metrics.NewRegistry()
+		}
+		s.metrics = newServerMetrics(opts)
+		s.rtr.Handler(http.MethodGet, cleanPath(opts.Path), opts.Registry.Handler())
+	}
+}
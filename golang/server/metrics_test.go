@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/crossedbot/common/golang/metrics"
+)
+
+func TestWithMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	s := New(":8080", 10, 10, WithMetrics(MetricsOptions{
+		Registry:  registry,
+		Namespace: "test",
+	})).(*server)
+	s.run = 1
+	var inFlight float64
+	err := s.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			inFlight = s.metrics.inFlight.Value()
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("hello"))
+		},
+		http.MethodPost, "/items/:id",
+	)
+	require.Nil(t, err)
+	for _, id := range []string{"1", "2"} {
+		r, err := http.NewRequest(http.MethodPost, "/items/"+id, nil)
+		require.Nil(t, err)
+		rr := httptest.NewRecorder()
+		s.handler().ServeHTTP(rr, r)
+		require.Equal(t, http.StatusCreated, rr.Code)
+	}
+	require.Equal(t, float64(1), inFlight)
+	require.Equal(t, float64(0), s.metrics.inFlight.Value())
+	require.Equal(t, float64(2), s.metrics.requests.Value("/items/:id", http.MethodPost, "201"))
+
+	r, err := http.NewRequest(http.MethodGet, DefaultMetricsPath, nil)
+	require.Nil(t, err)
+	rr := httptest.NewRecorder()
+	s.handler().ServeHTTP(rr, r)
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	require.True(t, strings.Contains(body,
+		`test_http_requests_total{route="/items/:id",method="POST",status="201"} 2`))
+	require.True(t, strings.Contains(body,
+		`test_http_response_size_bytes_sum{route="/items/:id",method="POST",status="201"} 10`))
+	require.True(t, strings.Contains(body,
+		`test_http_request_duration_seconds_count{route="/items/:id",method="POST",status="201"} 2`))
+	require.True(t, strings.Contains(body, "test_http_requests_in_flight 0"))
+}
+
+func TestWithMetricsMultipleServers(t *testing.T) {
+	a := New(":8080", 10, 10, WithMetrics(MetricsOptions{})).(*server)
+	b := New(":8080", 10, 10, WithMetrics(MetricsOptions{})).(*server)
+	require.NotSame(t, a.metrics.requests, b.metrics.requests)
+
+	registry := metrics.NewRegistry()
+	c := New(":8080", 10, 10, WithMetrics(MetricsOptions{Registry: registry})).(*server)
+	d := New(":8080", 10, 10, WithMetrics(MetricsOptions{Registry: registry})).(*server)
+	require.Same(t, c.metrics.requests, d.metrics.requests)
+	require.Same(t, c.metrics.inFlight, d.metrics.inFlight)
+}
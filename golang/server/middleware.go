@@ -0,0 +1,19 @@
+package server
+
+// Middleware represents a function that wraps a Handler; returning a new
+// Handler that may act before and/or after calling the wrapped one.
+type Middleware func(Handler) Handler
+
+// applyRoute appends the middleware to the route configuration.
+func (m Middleware) applyRoute(cfg *routeConfig) {
+	cfg.middleware = append(cfg.middleware, m)
+}
+
+// chain wraps the handler with the given middleware. The first middleware in
+// the list is the outermost, and is therefore the first to be executed.
+func chain(handler Handler, middleware []Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
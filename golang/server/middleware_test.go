@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain(t *testing.T) {
+	order := []string{}
+	mw := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w http.ResponseWriter, r *http.Request, p Parameters) {
+				order = append(order, name)
+				next(w, r, p)
+			}
+		}
+	}
+	handler := chain(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			order = append(order, "handler")
+		},
+		[]Middleware{mw("first"), mw("second")},
+	)
+	r, err := http.NewRequest(http.MethodGet, "/hello", nil)
+	require.Nil(t, err)
+	handler(httptest.NewRecorder(), r, Parameters{})
+	require.Equal(t, []string{"first", "second", "handler"}, order)
+}
+
+func TestMiddlewareApplyRoute(t *testing.T) {
+	cfg := routeConfig{}
+	Middleware(func(next Handler) Handler { return next }).applyRoute(&cfg)
+	require.Equal(t, 1, len(cfg.middleware))
+}
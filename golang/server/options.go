@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net"
+	"net/http"
+)
+
+// Option can be used to configure a server when it is created.
+type Option func(s *server)
+
+// WithNotFoundHandler replaces the handler called when no route matches the
+// request's path.
+func WithNotFoundHandler(handler http.Handler) Option {
+	return func(s *server) {
+		s.rtr.NotFound = handler
+	}
+}
+
+// WithMethodNotAllowedHandler replaces the handler called when a route matches
+// the request's path but not its method. The Allow header, listing the
+// permitted methods, is set before the handler is called.
+func WithMethodNotAllowedHandler(handler http.Handler) Option {
+	return func(s *server) {
+		s.rtr.MethodNotAllowed = handler
+	}
+}
+
+// WithHealth registers the liveness and readiness endpoints, reporting the
+// results of the server's health checks. The endpoints bypass all middleware
+// and remain available while the server is draining.
+func WithHealth(opts HealthOptions) Option {
+	return func(s *server) {
+		if opts.LivenessPath == "" {
+			opts.LivenessPath = DefaultLivenessPath
+		}
+		if opts.ReadinessPath == "" {
+			opts.ReadinessPath = DefaultReadinessPath
+		}
+		s.rtr.GET(cleanPath(opts.LivenessPath), s.health.handler(s.health.Liveness))
+		s.rtr.GET(cleanPath(opts.ReadinessPath), s.health.handler(s.health.Readiness))
+		s.drainDelay = opts.DrainDelay
+	}
+}
+
+// WithCors applies the CORS policy to all routes of the server, unless a route
+// or its group is given its own policy; see Cors.
+func WithCors(policy CorsPolicy) Option {
+	return func(s *server) {
+		s.cors = newCors(policy)
+	}
+}
+
+// WithAccessLog logs a structured entry for every request the server receives,
+// including those that are not routed and those answered by the health and
+// metrics endpoints.
+func WithAccessLog(opts AccessLogOptions) Option {
+	return func(s *server) {
+		s.accessLog = &opts
+	}
+}
+
+// WithBeforeStop adds a hook that is run when the server is stopping, after it
+// begins refusing new requests but before its listener is closed.
+func WithBeforeStop(hook StopHook) Option {
+	return func(s *server) {
+		s.beforeStop = append(s.beforeStop, hook)
+	}
+}
+
+// WithAfterStop adds a hook that is run after the server has stopped and its
+// in-flight requests have drained or the stop deadline has passed.
+func WithAfterStop(hook StopHook) Option {
+	return func(s *server) {
+		s.afterStop = append(s.afterStop, hook)
+	}
+}
+
+// WithListeners serves requests from the given pre-opened listeners, e.g. those
+// returned by InheritedListeners, instead of listening on the server's
+// address. A pre-opened listener bound to the address of a ListenerConfig is
+// used by that configuration rather than binding the address again; others
+// serve TLS if it is enabled for the server. Listeners must be TCP or Unix
+// listeners.
+func WithListeners(listeners ...net.Listener) Option {
+	return func(s *server) {
+		s.listeners = append(s.listeners, listeners...)
+	}
+}
+
+// WithListener adds a listener to the server; sharing the server's routes,
+// middleware and lifecycle. The server continues to listen on its own address
+// unless the address is empty.
+func WithListener(cfg ListenerConfig) Option {
+	return func(s *server) {
+		s.configs = append(s.configs, cfg)
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithNotFoundHandler(t *testing.T) {
+	s := New(":8080", 10, 10, WithNotFoundHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		},
+	))).(*server)
+	r, err := http.NewRequest(http.MethodGet, "/missing", nil)
+	require.Nil(t, err)
+	rr := httptest.NewRecorder()
+	s.handler().ServeHTTP(rr, r)
+	require.Equal(t, http.StatusTeapot, rr.Code)
+}
+
+func TestWithMethodNotAllowedHandler(t *testing.T) {
+	var allow string
+	s := New(":8080", 10, 10, WithMethodNotAllowedHandler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			allow = w.Header().Get("Allow")
+			w.WriteHeader(http.StatusTeapot)
+		},
+	))).(*server)
+	s.run = 1
+	require.Nil(t, s.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {},
+		http.MethodGet, "/hello",
+	))
+	r, err := http.NewRequest(http.MethodPost, "/hello", nil)
+	require.Nil(t, err)
+	rr := httptest.NewRecorder()
+	s.handler().ServeHTTP(rr, r)
+	require.Equal(t, http.StatusTeapot, rr.Code)
+	require.Contains(t, allow, http.MethodGet)
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/crossedbot/common/golang/db"
+)
+
+const (
+	// DefaultPageLimit is the default number of items per page.
+	DefaultPageLimit = 20
+
+	// MaxPageLimit is the default maximum number of items per page.
+	MaxPageLimit = 100
+)
+
+// PaginationOptions represents the bounds of pagination query parameters.
+type PaginationOptions struct {
+	// DefaultLimit is the number of items of a page if no limit is
+	// requested; defaults to DefaultPageLimit.
+	DefaultLimit int
+
+	// MaxLimit is the maximum number of items of a page; defaults to
+	// MaxPageLimit.
+	MaxLimit int
+
+	// Sortable are the columns items may be sorted by. Requests to sort by
+	// any other column are rejected.
+	Sortable []string
+
+	// DefaultSort is the sort order if none is requested, in the format of
+	// the sort query parameter, e.g. "-created_at,id".
+	DefaultSort string
+}
+
+// Pagination represents the page of items requested.
+type Pagination struct {
+	Limit  int
+	Offset int
+	Sort   []db.Sort
+}
+
+// Page represents a page of items and the cursors of its neighbouring pages.
+type Page struct {
+	XMLName    xml.Name    `json:"-" xml:"page"`
+	Data       interface{} `json:"data" xml:"data"`
+	Total      int64       `json:"total" xml:"total"`
+	Limit      int         `json:"limit" xml:"limit"`
+	Offset     int         `json:"offset" xml:"offset"`
+	NextCursor string      `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
+	PrevCursor string      `json:"prev_cursor,omitempty" xml:"prev_cursor,omitempty"`
+}
+
+// cursor represents the position encoded in an opaque page cursor.
+type cursor struct {
+	Offset int `json:"o"`
+}
+
+// ParsePagination parses the limit, offset, cursor and sort query parameters
+// of the request. A cursor, as returned in a Page, takes precedence over the
+// offset. The sort parameter is a comma-separated list of columns, each
+// prefixed with "-" for descending order. If any parameter is invalid or out
+// of bounds, ValidationErrors is returned.
+func ParsePagination(r *http.Request, opts PaginationOptions) (Pagination, error) {
+	if opts.DefaultLimit <= 0 {
+		opts.DefaultLimit = DefaultPageLimit
+	}
+	if opts.MaxLimit <= 0 {
+		opts.MaxLimit = MaxPageLimit
+	}
+	q := r.URL.Query()
+	errs := ValidationErrors{}
+	p := Pagination{Limit: opts.DefaultLimit}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		switch {
+		case err != nil:
+			errs = append(errs, ValidationError{"limit", "integer", "must be an integer"})
+		case limit < 1:
+			errs = append(errs, ValidationError{"limit", "min", "must be at least 1"})
+		case limit > opts.MaxLimit:
+			errs = append(errs, ValidationError{
+				"limit", "max", fmt.Sprintf("must be at most %d", opts.MaxLimit),
+			})
+		default:
+			p.Limit = limit
+		}
+	}
+	if v := q.Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil {
+			errs = append(errs, ValidationError{"cursor", "cursor", "must be a valid cursor"})
+		}
+		p.Offset = c.Offset
+	} else if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		switch {
+		case err != nil:
+			errs = append(errs, ValidationError{"offset", "integer", "must be an integer"})
+		case offset < 0:
+			errs = append(errs, ValidationError{"offset", "min", "must be at least 0"})
+		default:
+			p.Offset = offset
+		}
+	}
+	sort := q.Get("sort")
+	if sort == "" {
+		sort = opts.DefaultSort
+	}
+	for _, field := range strings.Split(sort, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		s := db.Sort{Column: strings.TrimLeft(field, "+-")}
+		s.Desc = strings.HasPrefix(field, "-")
+		if !containsAny(opts.Sortable, s.Column) {
+			errs = append(errs, ValidationError{
+				"sort", "oneof", fmt.Sprintf(
+					"must be one of [%s]", strings.Join(opts.Sortable, ", "),
+				),
+			})
+			break
+		}
+		p.Sort = append(p.Sort, s)
+	}
+	if len(errs) > 0 {
+		return Pagination{}, errs
+	}
+	return p, nil
+}
+
+// Page returns the pagination as a database page.
+func (p Pagination) Page() db.Page {
+	return db.Page{Limit: p.Limit, Offset: p.Offset, Sort: p.Sort}
+}
+
+// NewPage returns the page of data, of the given total number of items, for
+// the pagination.
+func NewPage(p Pagination, data interface{}, total int64) Page {
+	page := Page{
+		Data:   data,
+		Total:  total,
+		Limit:  p.Limit,
+		Offset: p.Offset,
+	}
+	if int64(p.Offset+p.Limit) < total {
+		page.NextCursor = encodeCursor(cursor{Offset: p.Offset + p.Limit})
+	}
+	if p.Offset > 0 {
+		prev := p.Offset - p.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		page.PrevCursor = encodeCursor(cursor{Offset: prev})
+	}
+	return page
+}
+
+// PageResponse writes the page of data, of the given total number of items,
+// in the media type best matching the request's Accept header; see Respond.
+// The first, last and neighbouring pages are linked by an RFC 5988 Link
+// header.
+func PageResponse(w http.ResponseWriter, r *http.Request, p Pagination, data interface{}, total int64) {
+	page := NewPage(p, data, total)
+	if link := pageLinks(r.URL, page); link != "" {
+		w.Header().Set("Link", link)
+	}
+	Respond(w, r, page, http.StatusOK)
+}
+
+// Paginate reads the page of records matching the query, as requested by the
+// pagination query parameters, into out, a pointer to a slice, and writes it
+// as a Page; see ParsePagination and PageResponse. Errors are written by
+// ErrorResponse.
+func Paginate(w http.ResponseWriter, r *http.Request, d db.Database, out interface{}, opts PaginationOptions, query interface{}, args ...interface{}) {
+	p, err := ParsePagination(r, opts)
+	if err != nil {
+		ErrorResponse(w, err)
+		return
+	}
+	total, err := d.ReadPage(out, p.Page(), query, args...)
+	if err != nil {
+		ErrorResponse(w, WrapError(err, ErrProcessingRequestCode, "Failed to read page"))
+		return
+	}
+	PageResponse(w, r, p, out, total)
+}
+
+// pageLinks returns the Link header of the page; linking the first, previous,
+// next and last pages relative to the request URL.
+func pageLinks(u *url.URL, page Page) string {
+	link := func(rel string, c *cursor) string {
+		q := u.Query()
+		q.Del("offset")
+		q.Del("cursor")
+		if c != nil {
+			q.Set("cursor", encodeCursor(*c))
+		}
+		target := url.URL{Path: u.Path, RawQuery: q.Encode()}
+		return fmt.Sprintf("<%s>; rel=\"%s\"", target.String(), rel)
+	}
+	links := []string{link("first", nil)}
+	if page.PrevCursor != "" {
+		c, _ := decodeCursor(page.PrevCursor)
+		links = append(links, link("prev", &c))
+	}
+	if page.NextCursor != "" {
+		c, _ := decodeCursor(page.NextCursor)
+		links = append(links, link("next", &c))
+	}
+	if page.Limit > 0 && page.Total > 0 {
+		last := int((page.Total - 1) / int64(page.Limit) * int64(page.Limit))
+		links = append(links, link("last", &cursor{Offset: last}))
+	}
+	return strings.Join(links, ", ")
+}
+
+// encodeCursor returns the opaque cursor of the position.
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor returns the position of the opaque cursor.
+func decodeCursor(s string) (cursor, error) {
+	c := cursor{}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return cursor{}, err
+	}
+	if c.Offset < 0 {
+		return cursor{}, fmt.Errorf("invalid cursor offset %d", c.Offset)
+	}
+	return c, nil
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/crossedbot/common/golang/db"
+)
+
+type pageItem struct {
+	Id   int    `json:"id" gorm:"primaryKey"`
+	Name string `json:"name"`
+}
+
+func TestParsePagination(t *testing.T) {
+	opts := PaginationOptions{
+		MaxLimit:    50,
+		Sortable:    []string{"name", "created_at"},
+		DefaultSort: "-created_at",
+	}
+	r := httptest.NewRequest(http.MethodGet, "/items", nil)
+	p, err := ParsePagination(r, opts)
+	require.Nil(t, err)
+	require.Equal(t, Pagination{
+		Limit: DefaultPageLimit,
+		Sort:  []db.Sort{{Column: "created_at", Desc: true}},
+	}, p)
+
+	r = httptest.NewRequest(http.MethodGet, "/items?limit=10&offset=30&sort=name,-created_at", nil)
+	p, err = ParsePagination(r, opts)
+	require.Nil(t, err)
+	require.Equal(t, db.Page{
+		Limit:  10,
+		Offset: 30,
+		Sort: []db.Sort{
+			{Column: "name"},
+			{Column: "created_at", Desc: true},
+		},
+	}, p.Page())
+
+	c := encodeCursor(cursor{Offset: 40})
+	r = httptest.NewRequest(http.MethodGet, "/items?offset=10&cursor="+c, nil)
+	p, err = ParsePagination(r, opts)
+	require.Nil(t, err)
+	require.Equal(t, 40, p.Offset)
+
+	r = httptest.NewRequest(http.MethodGet, "/items?limit=51&offset=-1&sort=password&cursor=", nil)
+	_, err = ParsePagination(r, opts)
+	errs := ValidationErrors{}
+	require.True(t, errors.As(err, &errs))
+	require.Equal(t, []string{"limit", "offset", "sort"}, []string{
+		errs[0].Field, errs[1].Field, errs[2].Field,
+	})
+
+	r = httptest.NewRequest(http.MethodGet, "/items?cursor=invalid", nil)
+	_, err = ParsePagination(r, opts)
+	require.NotNil(t, err)
+}
+
+func TestNewPage(t *testing.T) {
+	page := NewPage(Pagination{Limit: 10, Offset: 5}, []int{}, 30)
+	require.NotEmpty(t, page.NextCursor)
+	require.NotEmpty(t, page.PrevCursor)
+	next, err := decodeCursor(page.NextCursor)
+	require.Nil(t, err)
+	require.Equal(t, 15, next.Offset)
+	prev, err := decodeCursor(page.PrevCursor)
+	require.Nil(t, err)
+	require.Equal(t, 0, prev.Offset)
+
+	page = NewPage(Pagination{Limit: 10}, []int{}, 10)
+	require.Empty(t, page.NextCursor)
+	require.Empty(t, page.PrevCursor)
+}
+
+func TestPaginate(t *testing.T) {
+	d := db.New("sqlite3")
+	require.Nil(t, d.Open(filepath.Join(t.TempDir(), "test.db")))
+	t.Cleanup(func() { d.Close() })
+	require.Nil(t, d.Tx(func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&pageItem{})
+	}))
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		require.Nil(t, d.Create(&pageItem{Name: name}))
+	}
+	opts := PaginationOptions{Sortable: []string{"id", "name"}}
+	list := func(target string) (*httptest.ResponseRecorder, Page, []pageItem) {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		rr := httptest.NewRecorder()
+		Paginate(rr, r, d, &[]pageItem{}, opts, "name <> ?", "c")
+		items := []pageItem{}
+		page := Page{Data: &items}
+		require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &page))
+		return rr, page, items
+	}
+
+	rr, page, items := list("/items?limit=2&sort=-name&filter=x")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, int64(4), page.Total)
+	require.Equal(t, []string{"e", "d"}, []string{items[0].Name, items[1].Name})
+	require.Empty(t, page.PrevCursor)
+	links := rr.Header().Get("Link")
+	require.Contains(t, links, `</items?filter=x&limit=2&sort=-name>; rel="first"`)
+	require.Contains(t, links, `rel="next"`)
+	require.Contains(t, links, `rel="last"`)
+	require.NotContains(t, links, `rel="prev"`)
+
+	// Follow the next link
+	next := strings.SplitN(strings.SplitN(links, "<", 3)[2], ">", 2)[0]
+	u, err := url.Parse(next)
+	require.Nil(t, err)
+	require.Equal(t, page.NextCursor, u.Query().Get("cursor"))
+	rr, page, items = list(next)
+	require.Equal(t, []string{"b", "a"}, []string{items[0].Name, items[1].Name})
+	require.Empty(t, page.NextCursor)
+	require.NotEmpty(t, page.PrevCursor)
+	require.Contains(t, rr.Header().Get("Link"), `rel="prev"`)
+
+	rr = httptest.NewRecorder()
+	Paginate(rr, httptest.NewRequest(http.MethodGet, "/items?sort=secret", nil),
+		d, &[]pageItem{}, opts, nil)
+	require.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+}
@@ -0,0 +1,387 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/crossedbot/common/golang/db"
+	"github.com/crossedbot/common/golang/logger"
+)
+
+// RateLimitAlgorithm represents an algorithm used to limit request rates.
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket allows bursts of up to the limit's number of requests,
+	// refilling the bucket evenly over the limit's period.
+	TokenBucket RateLimitAlgorithm = iota
+
+	// SlidingWindow allows up to the limit's number of requests within any
+	// period; approximated by weighting the previous fixed window.
+	SlidingWindow
+)
+
+// RateLimit represents a limit of requests per period.
+type RateLimit struct {
+	Requests  int                // number of requests allowed per period
+	Period    time.Duration      // period of the limit
+	Algorithm RateLimitAlgorithm // algorithm enforcing the limit
+}
+
+// RateLimitResult represents the outcome of taking a request from a limit.
+type RateLimitResult struct {
+	Allowed    bool          // indicates whether the request is allowed
+	Limit      int           // number of requests allowed per period
+	Remaining  int           // number of requests remaining
+	Reset      time.Duration // time until the limit is fully reset
+	RetryAfter time.Duration // time until a request is allowed again
+}
+
+// RateLimitStore represents storage for the state of rate limits.
+type RateLimitStore interface {
+	// Take takes a single request from the limit for the given key. Keys
+	// are hashed by the rate limiter, so client credentials such as API
+	// keys never reach the store.
+	Take(key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// RateLimitKeyFunc returns the key identifying the client of a request. If the
+// key is empty, the client is identified by its IP address instead.
+type RateLimitKeyFunc func(r *http.Request) string
+
+// KeyByIp returns a key function identifying clients by their IP address.
+func KeyByIp() RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+}
+
+// KeyByHeader returns a key function identifying clients by the value of the
+// given request header, e.g. an API key.
+func KeyByHeader(name string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// KeyByApiKey returns a key function identifying clients by their API key in
+// the X-API-Key header, or the Authorization header using the ApiKey scheme.
+func KeyByApiKey() RateLimitKeyFunc {
+	return requestApiKey
+}
+
+// RateLimitOptions represents the configuration of the rate limiting
+// middleware.
+type RateLimitOptions struct {
+	// Limit is the limit enforced per client.
+	Limit RateLimit
+
+	// Key identifies the client of a request; defaults to KeyByIp.
+	Key RateLimitKeyFunc
+
+	// Store holds the state of the limits; defaults to a new in-memory
+	// store.
+	Store RateLimitStore
+
+	// Name namespaces the keys of the limiter within the store. Limiters
+	// sharing a store and name share their limits.
+	Name string
+}
+
+// RateLimiter returns a middleware that limits the rate of requests per
+// client. Limited requests are rejected with 429 Too Many Requests. The
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers are
+// set on all responses, and Retry-After on rejected ones. If the store fails,
+// the request is allowed.
+func RateLimiter(opts RateLimitOptions) Middleware {
+	key := opts.Key
+	if key == nil {
+		key = KeyByIp()
+	}
+	store := opts.Store
+	if store == nil {
+		store = NewMemoryRateLimitStore()
+	}
+	byIp := KeyByIp()
+	return func(next Handler) Handler {
+		return func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			k := key(r)
+			if k == "" {
+				k = "ip:" + byIp(r)
+			}
+			res, err := store.Take(opts.Name+":"+hashKey(k), opts.Limit)
+			if err != nil {
+				logger.Error(fmt.Sprintf(
+					"server: failed to take rate limit; %s",
+					err.Error(),
+				))
+				next(w, r, p)
+				return
+			}
+			h := w.Header()
+			h.Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+			h.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("X-RateLimit-Reset", strconv.Itoa(seconds(res.Reset)))
+			if !res.Allowed {
+				h.Set("Retry-After", strconv.Itoa(seconds(res.RetryAfter)))
+				JsonResponse(w, Error{
+					Code:    ErrTooManyRequestsCode,
+					Message: "Too many requests",
+				}, http.StatusTooManyRequests)
+				return
+			}
+			next(w, r, p)
+		}
+	}
+}
+
+// hashKey returns the hex encoded SHA-256 hash of the key.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// seconds returns the duration in whole seconds, rounded up.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// rateLimitState represents the state of a limit for a single key.
+type rateLimitState struct {
+	Tokens   float64   // remaining tokens of a token bucket
+	Current  int       // requests in the current window
+	Previous int       // requests in the previous window
+	Start    time.Time // start of the current window, or the last refill
+}
+
+// take takes a single request from the state at the given time.
+func (l RateLimit) take(state *rateLimitState, now time.Time) RateLimitResult {
+	if l.Requests <= 0 || l.Period <= 0 {
+		return RateLimitResult{Limit: l.Requests, RetryAfter: l.Period}
+	}
+	if l.Algorithm == SlidingWindow {
+		return l.takeWindow(state, now)
+	}
+	return l.takeToken(state, now)
+}
+
+// takeToken takes a token from the bucket.
+func (l RateLimit) takeToken(state *rateLimitState, now time.Time) RateLimitResult {
+	capacity := float64(l.Requests)
+	rate := capacity / l.Period.Seconds() // tokens per second
+	if state.Start.IsZero() {
+		state.Tokens = capacity
+	} else if elapsed := now.Sub(state.Start).Seconds(); elapsed > 0 {
+		state.Tokens = math.Min(capacity, state.Tokens+elapsed*rate)
+	}
+	state.Start = now
+	res := RateLimitResult{Limit: l.Requests}
+	if state.Tokens >= 1 {
+		state.Tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = duration((1 - state.Tokens) / rate)
+	}
+	res.Remaining = int(state.Tokens)
+	res.Reset = duration((capacity - state.Tokens) / rate)
+	return res
+}
+
+// takeWindow takes a request from the sliding window.
+func (l RateLimit) takeWindow(state *rateLimitState, now time.Time) RateLimitResult {
+	if state.Start.IsZero() {
+		state.Start = now
+	}
+	if elapsed := now.Sub(state.Start); elapsed >= l.Period {
+		windows := elapsed / l.Period
+		state.Previous = state.Current
+		if windows > 1 {
+			state.Previous = 0
+		}
+		state.Current = 0
+		state.Start = state.Start.Add(windows * l.Period)
+	}
+	elapsed := now.Sub(state.Start)
+	weight := 1 - elapsed.Seconds()/l.Period.Seconds()
+	count := float64(state.Previous)*weight + float64(state.Current)
+	res := RateLimitResult{Limit: l.Requests}
+	if count+1 <= float64(l.Requests) {
+		state.Current++
+		count++
+		res.Allowed = true
+	} else if state.Previous == 0 || state.Current >= l.Requests {
+		res.RetryAfter = l.Period - elapsed
+	} else {
+		// Time until the previous window's weight has decayed enough
+		excess := count + 1 - float64(l.Requests)
+		res.RetryAfter = duration(
+			excess / float64(state.Previous) * l.Period.Seconds(),
+		)
+	}
+	res.Remaining = int(math.Max(0, float64(l.Requests)-math.Ceil(count)))
+	switch {
+	case state.Current > 0:
+		res.Reset = 2*l.Period - elapsed
+	case state.Previous > 0:
+		res.Reset = l.Period - elapsed
+	}
+	return res
+}
+
+// duration returns the number of seconds as a duration.
+func duration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// memoryRateLimitState represents the state of a limit held in memory.
+type memoryRateLimitState struct {
+	rateLimitState
+	expires time.Time // time the state can be discarded
+}
+
+// memoryRateLimitStore implements the RateLimitStore interface in memory.
+type memoryRateLimitStore struct {
+	mu     sync.Mutex
+	states map[string]*memoryRateLimitState
+	sweep  time.Time // time of the next sweep of expired states
+	now    func() time.Time
+}
+
+// NewMemoryRateLimitStore returns a new rate limit store holding its state in
+// memory. The state is not shared between server instances.
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{
+		states: map[string]*memoryRateLimitState{},
+		now:    time.Now,
+	}
+}
+
+// Take takes a single request from the limit for the given key.
+func (s *memoryRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.After(s.sweep) {
+		for k, state := range s.states {
+			if now.After(state.expires) {
+				delete(s.states, k)
+			}
+		}
+		s.sweep = now.Add(time.Minute)
+	}
+	state, ok := s.states[key]
+	if !ok {
+		state = &memoryRateLimitState{}
+		s.states[key] = state
+	}
+	res := limit.take(&state.rateLimitState, now)
+	state.expires = now.Add(2 * limit.Period)
+	return res, nil
+}
+
+// RateLimitRecord represents the state of a limit stored in a database.
+type RateLimitRecord struct {
+	Key       string `gorm:"column:limit_key;primaryKey;size:255"`
+	Tokens    float64
+	Current   int
+	Previous  int
+	Start     time.Time
+	ExpiresAt time.Time `gorm:"index"`
+	UpdatedAt time.Time
+}
+
+// TableName returns the name of the table holding rate limit records.
+func (RateLimitRecord) TableName() string {
+	return "rate_limits"
+}
+
+// databaseRateLimitStore implements the RateLimitStore interface using a
+// database.
+type databaseRateLimitStore struct {
+	db    db.Database
+	mu    sync.Mutex
+	sweep time.Time // time of the next sweep of expired records
+	now   func() time.Time
+}
+
+// NewDatabaseRateLimitStore returns a new rate limit store holding its state
+// in the given database; allowing limits to be shared between server
+// instances. The rate_limits table is created if it does not exist, and
+// records are deleted once they have not been updated for twice their limit's
+// period.
+func NewDatabaseRateLimitStore(d db.Database) (RateLimitStore, error) {
+	err := d.Tx(func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&RateLimitRecord{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &databaseRateLimitStore{db: d, now: time.Now}, nil
+}
+
+// Take takes a single request from the limit for the given key.
+func (s *databaseRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	now := s.now()
+	if err := s.expire(now); err != nil {
+		return RateLimitResult{}, err
+	}
+	var res RateLimitResult
+	err := s.db.Tx(func(tx *gorm.DB) error {
+		rec := RateLimitRecord{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("limit_key = ?", key).First(&rec).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		state := rateLimitState{
+			Tokens:   rec.Tokens,
+			Current:  rec.Current,
+			Previous: rec.Previous,
+			Start:    rec.Start,
+		}
+		res = limit.take(&state, now)
+		rec = RateLimitRecord{
+			Key:       key,
+			Tokens:    state.Tokens,
+			Current:   state.Current,
+			Previous:  state.Previous,
+			Start:     state.Start,
+			ExpiresAt: now.Add(2 * limit.Period),
+		}
+		return tx.Save(&rec).Error
+	})
+	return res, err
+}
+
+// expire deletes the expired records, at most once a minute.
+func (s *databaseRateLimitStore) expire(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !now.After(s.sweep) {
+		return nil
+	}
+	err := s.db.Tx(func(tx *gorm.DB) error {
+		return tx.Where("expires_at < ?", now).
+			Delete(&RateLimitRecord{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	s.sweep = now.Add(time.Minute)
+	return nil
+}
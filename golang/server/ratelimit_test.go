@@ -0,0 +1,172 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/crossedbot/common/golang/db"
+)
+
+func TestRateLimitTakeToken(t *testing.T) {
+	limit := RateLimit{Requests: 2, Period: 2 * time.Second}
+	state := &rateLimitState{}
+	now := time.Now()
+	res := limit.take(state, now)
+	require.True(t, res.Allowed)
+	require.Equal(t, 1, res.Remaining)
+	res = limit.take(state, now)
+	require.True(t, res.Allowed)
+	require.Equal(t, 0, res.Remaining)
+	require.Equal(t, 2*time.Second, res.Reset)
+	res = limit.take(state, now)
+	require.False(t, res.Allowed)
+	require.Equal(t, time.Second, res.RetryAfter)
+	res = limit.take(state, now.Add(time.Second))
+	require.True(t, res.Allowed)
+}
+
+func TestRateLimitTakeWindow(t *testing.T) {
+	limit := RateLimit{Requests: 2, Period: 10 * time.Second, Algorithm: SlidingWindow}
+	state := &rateLimitState{}
+	now := time.Now()
+	require.True(t, limit.take(state, now).Allowed)
+	require.True(t, limit.take(state, now.Add(time.Second)).Allowed)
+	res := limit.take(state, now.Add(2*time.Second))
+	require.False(t, res.Allowed)
+	require.Equal(t, 8*time.Second, res.RetryAfter)
+
+	// Half way through the next window, one of the previous window's
+	// requests still counts
+	res = limit.take(state, now.Add(15*time.Second))
+	require.True(t, res.Allowed)
+	require.Equal(t, 0, res.Remaining)
+	res = limit.take(state, now.Add(15*time.Second))
+	require.False(t, res.Allowed)
+	require.Equal(t, 5*time.Second, res.RetryAfter)
+
+	// After two windows all requests are forgotten
+	res = limit.take(state, now.Add(40*time.Second))
+	require.True(t, res.Allowed)
+	require.Equal(t, 1, res.Remaining)
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryRateLimitStore().(*memoryRateLimitStore)
+	store.now = func() time.Time { return now }
+	limit := RateLimit{Requests: 1, Period: time.Second}
+	res, err := store.Take("a", limit)
+	require.Nil(t, err)
+	require.True(t, res.Allowed)
+	res, err = store.Take("a", limit)
+	require.Nil(t, err)
+	require.False(t, res.Allowed)
+	res, err = store.Take("b", limit)
+	require.Nil(t, err)
+	require.True(t, res.Allowed)
+
+	now = now.Add(time.Hour)
+	_, err = store.Take("b", limit)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(store.states))
+}
+
+func TestDatabaseRateLimitStore(t *testing.T) {
+	d := db.New("sqlite3")
+	require.Nil(t, d.Open(filepath.Join(t.TempDir(), "test.db")))
+	defer d.Close()
+	now := time.Now()
+	store, err := NewDatabaseRateLimitStore(d)
+	require.Nil(t, err)
+	store.(*databaseRateLimitStore).now = func() time.Time { return now }
+	limit := RateLimit{Requests: 1, Period: time.Minute}
+	res, err := store.Take("a", limit)
+	require.Nil(t, err)
+	require.True(t, res.Allowed)
+	res, err = store.Take("a", limit)
+	require.Nil(t, err)
+	require.False(t, res.Allowed)
+	res, err = store.Take("b", limit)
+	require.Nil(t, err)
+	require.True(t, res.Allowed)
+
+	now = now.Add(time.Hour)
+	_, err = store.Take("b", limit)
+	require.Nil(t, err)
+	var count int64
+	require.Nil(t, d.Tx(func(tx *gorm.DB) error {
+		return tx.Model(&RateLimitRecord{}).Count(&count).Error
+	}))
+	require.Equal(t, int64(1), count)
+}
+
+func TestRateLimiter(t *testing.T) {
+	s := New(":8080", 10, 10).(*server)
+	s.run = 1
+	api := s.Group("/api", RateLimiter(RateLimitOptions{
+		Limit: RateLimit{Requests: 1, Period: time.Minute},
+		Key:   KeyByApiKey(),
+	}))
+	require.Nil(t, api.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			w.WriteHeader(http.StatusOK)
+		},
+		http.MethodGet, "/hello",
+	))
+	request := func(key string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest(http.MethodGet, "/api/hello", nil)
+		require.Nil(t, err)
+		r.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		s.handler().ServeHTTP(rr, r)
+		return rr
+	}
+	rr := request("a")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "1", rr.Header().Get("X-RateLimit-Limit"))
+	require.Equal(t, "0", rr.Header().Get("X-RateLimit-Remaining"))
+	require.Equal(t, "60", rr.Header().Get("X-RateLimit-Reset"))
+	rr = request("a")
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+	require.Equal(t, "60", rr.Header().Get("Retry-After"))
+	require.Contains(t, rr.Body.String(), `"code":1009`)
+	require.Equal(t, http.StatusOK, request("b").Code)
+	// Requests without a key are limited by IP address
+	require.Equal(t, http.StatusOK, request("").Code)
+	require.Equal(t, http.StatusTooManyRequests, request("").Code)
+}
+
+func TestRateLimiterHashesKeys(t *testing.T) {
+	store := NewMemoryRateLimitStore().(*memoryRateLimitStore)
+	limiter := RateLimiter(RateLimitOptions{
+		Limit: RateLimit{Requests: 1, Period: time.Minute},
+		Key:   KeyByApiKey(),
+		Store: store,
+		Name:  "api",
+	})
+	handler := limiter(func(w http.ResponseWriter, r *http.Request, p Parameters) {})
+	r, err := http.NewRequest(http.MethodGet, "/hello", nil)
+	require.Nil(t, err)
+	r.Header.Set("X-API-Key", "secret")
+	handler(httptest.NewRecorder(), r, nil)
+	require.Equal(t, 1, len(store.states))
+	for k := range store.states {
+		require.Equal(t, "api:"+hashKey("secret"), k)
+		require.NotContains(t, k, "secret")
+	}
+}
+
+func TestKeyByIp(t *testing.T) {
+	r, err := http.NewRequest(http.MethodGet, "/hello", nil)
+	require.Nil(t, err)
+	r.RemoteAddr = "10.0.0.1:1234"
+	require.Equal(t, "10.0.0.1", KeyByIp()(r))
+	r.RemoteAddr = "10.0.0.1"
+	require.Equal(t, "10.0.0.1", KeyByIp()(r))
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/crossedbot/common/golang/crypto"
+)
+
+const (
+	// RequestIdHeader is the header carrying a request's correlation ID.
+	RequestIdHeader = "X-Request-ID"
+
+	// RequestIdLength is the length of generated request IDs.
+	RequestIdLength = 20
+
+	// MaxRequestIdLength is the maximum length of an accepted request ID.
+	MaxRequestIdLength = 128
+)
+
+// GetRequestId returns the request ID stored in the context. If no ID is
+// present, an empty string is returned.
+func GetRequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+// requestId wraps the handler such that every request carries a correlation
+// ID. A valid incoming ID is kept, otherwise a new one is generated. The ID is
+// stored in the request's context and echoed in the response header.
+func requestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIdHeader)
+		if !validRequestId(id) {
+			var err error
+			if id, err = crypto.GenerateRandomString(RequestIdLength); err != nil {
+				id = ""
+			}
+		}
+		if id != "" {
+			w.Header().Set(RequestIdHeader, id)
+			ctx := context.WithValue(r.Context(), requestIdKey, id)
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestId returns true if the ID is non-empty, no longer than
+// MaxRequestIdLength, and only contains alphanumerics or any of "-_.:".
+func validRequestId(id string) bool {
+	if len(id) == 0 || len(id) > MaxRequestIdLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestId returns the data as it is written in a response; an Error is
+// written with the response's request ID.
+func withRequestId(w http.ResponseWriter, data interface{}) interface{} {
+	id := w.Header().Get(RequestIdHeader)
+	switch d := data.(type) {
+	case Error:
+		return d.body(id)
+	case *Error:
+		if d != nil {
+			return d.body(id)
+		}
+	case Problem:
+		if d.RequestId == "" {
+			d.RequestId = id
+		}
+		return d
+	}
+	return data
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRequestId(t *testing.T) {
+	require.Equal(t, "", GetRequestId(context.Background()))
+	ctx := context.WithValue(context.Background(), requestIdKey, "abc")
+	require.Equal(t, "abc", GetRequestId(ctx))
+}
+
+func TestRequestId(t *testing.T) {
+	var actual string
+	h := requestId(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actual = GetRequestId(r.Context())
+	}))
+
+	r, err := http.NewRequest(http.MethodGet, "/hello", nil)
+	require.Nil(t, err)
+	r.Header.Set(RequestIdHeader, "abc-123")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	require.Equal(t, "abc-123", actual)
+	require.Equal(t, "abc-123", rr.Header().Get(RequestIdHeader))
+
+	r.Header.Set(RequestIdHeader, "bad id\n")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	require.Equal(t, RequestIdLength, len(actual))
+	require.NotEqual(t, "bad id\n", actual)
+	require.Equal(t, actual, rr.Header().Get(RequestIdHeader))
+}
+
+func TestValidRequestId(t *testing.T) {
+	require.True(t, validRequestId("abc-123_A.b:c"))
+	require.False(t, validRequestId(""))
+	require.False(t, validRequestId("abc 123"))
+	require.False(t, validRequestId(strings.Repeat("a", MaxRequestIdLength+1)))
+}
+
+func TestWithRequestId(t *testing.T) {
+	rr := httptest.NewRecorder()
+	e := Error{Code: ErrNotFoundCode, Message: "some message"}
+	require.Equal(t, errorBody{Code: e.Code, Message: e.Message}, withRequestId(rr, e))
+	rr.Header().Set(RequestIdHeader, "abc")
+	require.Equal(t, "abc", withRequestId(rr, e).(errorBody).RequestId)
+	require.Equal(t, "abc", withRequestId(rr, &e).(errorBody).RequestId)
+	require.Equal(t, "abc", withRequestId(rr, e.Problem(0)).(Problem).RequestId)
+	require.Equal(t, "hello", withRequestId(rr, "hello"))
+}
+
+func TestServerRequestIdErrors(t *testing.T) {
+	s := New(":8080", 10, 10).(*server)
+	s.run = 1
+	require.Nil(t, s.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			panic("oops")
+		},
+		http.MethodGet, "/panic",
+	))
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodGet, "/panic", ErrProcessingRequestCode},
+		{http.MethodGet, "/missing", ErrNotFoundCode},
+		{http.MethodPost, "/panic", ErrNotAllowedCode},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest(test.method, test.path, nil)
+		require.Nil(t, err)
+		r.Header.Set(RequestIdHeader, "abc")
+		rr := httptest.NewRecorder()
+		s.handler().ServeHTTP(rr, r)
+		actual := errorBody{}
+		require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &actual))
+		require.Equal(t, test.code, actual.Code)
+		require.Equal(t, "abc", actual.RequestId)
+	}
+}
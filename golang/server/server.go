@@ -4,9 +4,12 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"net"
 	"net/http"
+	"os"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -17,78 +20,240 @@ import (
 	"github.com/crossedbot/common/golang/logger"
 )
 
+// DefaultStopTimeout is the time given to in-flight requests to complete when
+// the server is reloaded.
+const DefaultStopTimeout = 30 * time.Second
+
+// StopHook is a function run while the server is stopping.
+type StopHook func(ctx context.Context) error
+
+// Router is an interface that represents a set of HTTP routes.
+type Router interface {
+	Add(handler Handler, method, path string, settings ...ResponseSetting) error
+	Handle(handler Handler, method, path string, options ...RouteOption) error
+	Use(middleware ...Middleware)
+	Group(prefix string, options ...RouteOption) Router
+	Static(prefix string, fsys fs.FS, opts StaticOptions, options ...RouteOption) error
+}
+
 // Server is interface that represents an HTTP server.
 type Server interface {
+	Router
 	Start() error
-	Stop() error
+	Stop(ctx context.Context) error
 	Reload() error
-	Add(handler Handler, method, path string, settings ...ResponseSetting) error
 	SetTlsConfiguration(enable bool, cfg *tls.Config)
+	SetTlsCertificate(certFile, keyFile string, watchInterval time.Duration) error
+	SetTlsClientAuth(auth ClientAuth) error
+	Health() *Health
+	Upgrade() (*os.Process, error)
 }
 
 // server implements the Server interface.
 type server struct {
-	addr       string             // server address
-	rto        int                // reader timeout
-	tlsEnabled bool               // indicates whether connections are tls secure
-	tlsConfig  *tls.Config        // tls configuration
-	rtr        *httprouter.Router // router
-	run        int32              // indicates whether the server is running or not atomically
-	srv        *http.Server       // server
-	wg         sync.WaitGroup     // tracks pending requests
-	wto        int                // writer timeout
+	accessLog  *AccessLogOptions        // access log configuration
+	addr       string                   // server address
+	afterStop  []StopHook               // hooks run after the server stops
+	beforeStop []StopHook               // hooks run before the listener closes
+	cert       *certificate             // reloadable tls certificate
+	certWatch  time.Duration            // interval to check certificate files for changes
+	clientAuth *clientAuthConfig        // tls client authentication
+	health     *Health                  // health checks
+	configs    []ListenerConfig         // additional listeners
+	drainDelay time.Duration            // delay between draining and stopping
+	cors       *cors                    // server CORS policy
+	listeners  []net.Listener           // pre-opened listeners
+	metrics    *serverMetrics           // request metrics
+	methods    map[string][]string      // methods added per path
+	middleware []Middleware             // global middleware
+	options    map[string]*optionsRoute // OPTIONS handlers per path
+	rto        int                      // reader timeout
+	tlsEnabled bool                     // indicates whether connections are tls secure
+	tlsConfig  *tls.Config              // tls configuration
+	upgraded   bool                     // indicates whether the sockets were passed to an upgrade
+	rtr        *httprouter.Router       // router
+	run        int32                    // indicates whether the server is running or not atomically
+	sockets    []socket                 // duplicated listening sockets
+	srv        *http.Server             // server
+	wg         sync.WaitGroup           // tracks pending requests
+	wto        int                      // writer timeout
+}
+
+// optionsRoute represents the OPTIONS handler of a path.
+type optionsRoute struct {
+	handle httprouter.Handle // handler of the route
+	auto   bool              // indicates whether the handler is automatic
 }
 
-// New returns a server at the given address.
-func New(addr string, readTimeoutSeconds, writeTimeoutSeconds int) Server {
-	return &server{
-		addr: addr,
-		rto:  readTimeoutSeconds,
-		rtr:  router(),
-		wto:  writeTimeoutSeconds,
+// New returns a server at the given address; applying all options to the
+// server.
+func New(addr string, readTimeoutSeconds, writeTimeoutSeconds int, options ...Option) Server {
+	s := &server{
+		addr:    addr,
+		health:  NewHealth(),
+		methods: map[string][]string{},
+		options: map[string]*optionsRoute{},
+		rto:     readTimeoutSeconds,
+		rtr:     router(),
+		wto:     writeTimeoutSeconds,
 	}
+	for _, opt := range options {
+		opt(s)
+	}
+	return s
 }
 
 // Start starts the server for accepting requests.
 func (s *server) Start() error {
-	var listener net.Listener
-	var err error
 	s.srv = &http.Server{
 		Addr:         s.addr,
-		Handler:      s.rtr,
+		Handler:      s.handler(),
 		ReadTimeout:  time.Duration(s.rto) * time.Second,
 		WriteTimeout: time.Duration(s.wto) * time.Second,
 	}
-	if s.tlsEnabled && s.tlsConfig == nil {
-		return fmt.Errorf("TLS enabled but TLS configuration is nil")
-	}
-	if s.tlsEnabled {
-		listener = tls.NewListener(listener, s.tlsConfig)
-	} else {
-		listener, err = net.Listen("tcp", s.addr)
+	if s.tlsRequired() {
+		cfg, err := s.tlsConfiguration()
 		if err != nil {
-			return fmt.Errorf(
-				"failed to create listener; %s",
-				err.Error(),
-			)
+			return err
 		}
+		s.srv.TLSConfig = cfg
+	}
+	listeners, err := s.listen()
+	if err != nil {
+		return err
+	}
+	if s.tlsRequired() && s.cert != nil {
+		s.cert.Watch(s.certWatch)
 	}
-	go s.srv.Serve(listener)
+	// Running before serving, so queued connections are not refused
+	s.health.SetDraining(false)
 	atomic.StoreInt32(&s.run, 1)
+	for i, l := range listeners {
+		if s.sockets[i].tls {
+			// The certificate is provided by the TLS configuration
+			go s.srv.ServeTLS(l, "", "")
+		} else {
+			go s.srv.Serve(l)
+		}
+	}
 	return nil
 }
 
-// Stop stops the server from accepting requests.
-func (s *server) Stop() error {
+// Stop gracefully stops the server. The server is marked as draining, failing
+// its readiness check, and continues to serve requests for the drain delay set
+// by WithHealth. New requests are then refused with 503 Service Unavailable,
+// responses to in-flight requests close their connections, and the
+// before-stop hooks are run before the listener is closed. Stop then waits for
+// in-flight requests to complete and runs the after-stop hooks. If the context
+// expires before the requests drain, the remaining connections are closed and
+// the context's error is returned.
+func (s *server) Stop(ctx context.Context) error {
+	err := s.stop(ctx)
+	for _, sock := range s.sockets {
+		sock.file.Close()
+		// An upgraded instance continues to serve from the socket
+		if sock.path != "" && !s.upgraded {
+			os.Remove(sock.path)
+		}
+	}
+	s.sockets = nil
+	return err
+}
+
+// stop gracefully stops the server; keeping the duplicated listening sockets
+// open so that the server can be restarted without refusing connections.
+func (s *server) stop(ctx context.Context) error {
+	if s.srv == nil {
+		return nil
+	}
+	s.health.SetDraining(true)
+	if s.drainDelay > 0 {
+		t := time.NewTimer(s.drainDelay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
+	s.refuse()
+	err := runStopHooks(ctx, s.beforeStop)
+	if e := s.shutdown(ctx); e != nil && err == nil {
+		err = e
+	}
+	if e := runStopHooks(ctx, s.afterStop); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// refuse refuses new requests, and closes the connections of responses to
+// in-flight requests.
+func (s *server) refuse() {
 	atomic.StoreInt32(&s.run, 0)
-	s.wg.Wait()
-	return s.srv.Shutdown(context.Background())
+	s.srv.SetKeepAlivesEnabled(false)
+}
+
+// shutdown closes the server's listeners and waits for in-flight requests to
+// complete. If the context expires before the requests drain, the remaining
+// connections are closed and the context's error is returned.
+func (s *server) shutdown(ctx context.Context) error {
+	err := s.srv.Shutdown(ctx)
+	if err != nil {
+		s.srv.Close()
+	}
+	// Hijacked connections are not tracked by Shutdown
+	if e := s.wait(ctx); e != nil && err == nil {
+		err = e
+	}
+	if s.cert != nil {
+		s.cert.Unwatch()
+	}
+	return err
+}
+
+// runStopHooks runs the hooks in order, returning the first error.
+func runStopHooks(ctx context.Context, hooks []StopHook) error {
+	var err error
+	for _, hook := range hooks {
+		if e := hook(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
 }
 
-// Reload restarts the server.
+// Reload restarts the server. If the server was configured with certificate
+// files, the certificate is reloaded instead; a running server presents it to
+// new connections without dropping established ones. Otherwise, in-flight
+// requests are given DefaultStopTimeout to complete, after which their
+// connections are closed. The listening sockets remain open while the server
+// restarts; connections are queued rather than refused. The stop hooks are not
+// run.
 func (s *server) Reload() error {
-	if err := s.Stop(); err != nil {
-		return err
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultStopTimeout)
+	defer cancel()
+	return s.reload(ctx)
+}
+
+// reload restarts the server, giving in-flight requests until the context
+// expires to complete.
+func (s *server) reload(ctx context.Context) error {
+	if s.cert != nil {
+		if err := s.cert.Load(); err != nil {
+			return err
+		}
+		if atomic.LoadInt32(&s.run) > 0 {
+			return nil
+		}
+	}
+	if s.srv != nil {
+		s.refuse()
+		if err := s.shutdown(ctx); err != nil {
+			logger.Error(fmt.Sprintf(
+				"server: failed to drain requests before reloading; %s",
+				err.Error(),
+			))
+		}
 	}
 	return s.Start()
 }
@@ -96,21 +261,230 @@ func (s *server) Reload() error {
 // Add adds a new handler for the given method at the given path; applying
 // all response settings to the response writer. Allowable methods include: GET,
 // HEAD, POST, PUT, PATCH, DELETE, and OPTIONS. CONNECT and TRACE are not
-// supported.
+// supported. The server's middleware is executed before the handler.
 func (s *server) Add(handler Handler, method, path string, settings ...ResponseSetting) error {
-	h := func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-		s.wg.Add(1)
-		defer s.wg.Done()
-		if atomic.LoadInt32(&s.run) < 1 {
-			JsonResponse(w, Error{
-				Code:    ErrServiceUnavailableCode,
-				Message: "Service is unavailable",
-			}, http.StatusServiceUnavailable)
+	return s.Handle(handler, method, path, settingOptions(settings)...)
+}
+
+// Handle adds a new handler for the given method at the given path, as Add
+// does, configured by the given route options; response settings and
+// middleware.
+//
+// Middleware is executed in the following order: global middleware in the
+// order it was passed to Use, then the route's middleware in the order it was
+// passed to Handle, and finally the handler. Request tracking and response
+// settings are applied before any middleware is executed.
+func (s *server) Handle(handler Handler, method, path string, options ...RouteOption) error {
+	return s.handle(handler, method, path, s.config(options))
+}
+
+// config returns the configuration of a route added to the server with the
+// given options.
+func (s *server) config(options []RouteOption) routeConfig {
+	cfg := newRouteConfig(options)
+	cfg.middleware = append(append([]Middleware{}, s.middleware...), cfg.middleware...)
+	if cfg.cors == nil {
+		cfg.cors = s.cors
+	}
+	return cfg
+}
+
+// Group returns a new router for the given path prefix. Routes added to the
+// group inherit the server's middleware, followed by the group's own response
+// settings and middleware.
+func (s *server) Group(prefix string, options ...RouteOption) Router {
+	return newGroup(s, nil, prefix, options)
+}
+
+// Upgrade starts a new instance of the server's executable, with the same
+// arguments, and passes it the listening sockets; see InheritedListeners. Both
+// processes accept connections until this server is stopped, which should be
+// done once the new instance is serving. Unix sockets are left in place when
+// this server is stopped.
+func (s *server) Upgrade() (*os.Process, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	files := make([]*os.File, len(s.sockets))
+	for i, sock := range s.sockets {
+		files[i] = sock.file
+	}
+	proc, err := upgrade(path, os.Args[1:], files)
+	if err != nil {
+		return nil, err
+	}
+	s.upgraded = true
+	return proc, nil
+}
+
+// listen returns the server's listeners. Listeners are created from the sockets
+// of a previous start. Otherwise each listener configuration uses the
+// pre-opened listener of the same address, or binds its address; remaining
+// pre-opened listeners are used as is. The server listens on its address if no
+// pre-opened listeners were given. The sockets are duplicated to outlive the
+// listeners.
+func (s *server) listen() ([]net.Listener, error) {
+	if len(s.sockets) > 0 {
+		files := make([]*os.File, len(s.sockets))
+		for i, sock := range s.sockets {
+			files[i] = sock.file
 		}
-		applyResponseSettings(w, settings)
-		handler(w, r, parameters(p))
+		return fileListeners(files)
+	}
+	configs := s.configs
+	if len(s.listeners) == 0 && (s.addr != "" || len(configs) == 0) {
+		configs = append([]ListenerConfig{{
+			Address: s.addr,
+			Tls:     s.tlsEnabled,
+		}}, configs...)
+	}
+	preopened := s.listeners
+	listeners := []net.Listener{}
+	sockets := []socket{}
+	created := []string{} // unix socket paths created by this call
+	fail := func(err error) ([]net.Listener, error) {
+		closeListeners(listeners)
+		closeListeners(preopened)
+		for _, path := range created {
+			os.Remove(path)
+		}
+		return nil, err
+	}
+	for _, cfg := range configs {
+		var l net.Listener
+		if i := matchListener(preopened, cfg); i >= 0 {
+			l = preopened[i]
+			preopened = append(preopened[:i:i], preopened[i+1:]...)
+		} else {
+			var err error
+			if l, err = cfg.listen(); err != nil {
+				return fail(err)
+			}
+			if cfg.unix() {
+				created = append(created, cfg.Address)
+			}
+		}
+		listeners = append(listeners, l)
+		sockets = append(sockets, socket{tls: cfg.Tls, path: cfg.path()})
+	}
+	for _, l := range preopened {
+		listeners = append(listeners, l)
+		sockets = append(sockets, socket{tls: s.tlsEnabled})
 	}
+	files, err := listenerFiles(listeners)
+	if err != nil {
+		preopened = nil
+		return fail(err)
+	}
+	for i, f := range files {
+		sockets[i].file = f
+	}
+	s.sockets = sockets
+	s.listeners = nil
+	s.upgraded = false
+	return listeners, nil
+}
+
+// tlsRequired returns true if any of the server's listeners serve TLS.
+func (s *server) tlsRequired() bool {
+	if s.tlsEnabled {
+		return true
+	}
+	for _, cfg := range s.configs {
+		if cfg.Tls {
+			return true
+		}
+	}
+	return false
+}
+
+// wait waits for all tracked requests to complete or for the context to
+// expire.
+func (s *server) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Health returns the server's health checks.
+func (s *server) Health() *Health {
+	return s.health
+}
+
+// Use appends the given middleware to the server's global middleware. Global
+// middleware is applied to all routes added after the call to Use.
+func (s *server) Use(middleware ...Middleware) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+func (s *server) SetTlsConfiguration(enabled bool, cfg *tls.Config) {
+	s.tlsEnabled = enabled
+	s.tlsConfig = cfg
+}
+
+// SetTlsCertificate enables TLS using the certificate and key at the given
+// file paths. If the watch interval is greater than zero, the files are
+// checked for changes at that interval while the server is running, and the
+// certificate is reloaded for new connections without dropping established
+// ones.
+func (s *server) SetTlsCertificate(certFile, keyFile string, watchInterval time.Duration) error {
+	cert, err := newCertificate(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	s.tlsEnabled = true
+	s.cert = cert
+	s.certWatch = watchInterval
+	return nil
+}
+
+// SetTlsClientAuth enables the authentication of clients by their TLS
+// certificates. The verified client identity is available to handlers via
+// GetClientIdentity.
+func (s *server) SetTlsClientAuth(auth ClientAuth) error {
+	cfg, err := newClientAuthConfig(auth)
+	if err != nil {
+		return err
+	}
+	s.clientAuth = cfg
+	return nil
+}
+
+// tlsConfiguration returns the TLS configuration used to serve connections.
+func (s *server) tlsConfiguration() (*tls.Config, error) {
+	if s.tlsConfig == nil && s.cert == nil {
+		return nil, fmt.Errorf("TLS enabled but TLS configuration is nil")
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.tlsConfig != nil {
+		cfg = s.tlsConfig.Clone()
+	}
+	if s.cert != nil {
+		cfg.GetCertificate = s.cert.GetCertificate
+	}
+	if s.clientAuth != nil {
+		s.clientAuth.apply(cfg)
+	}
+	return cfg, nil
+}
+
+// handle registers the handler for the given method and path; wrapping it with
+// the request tracking, response settings, and middleware. Unless an OPTIONS
+// handler is added for the path, OPTIONS requests are answered automatically
+// with the methods allowed for the path, and preflight requests by the route's
+// CORS policy; neither is passed through the route's middleware.
+func (s *server) handle(handler Handler, method, path string, cfg routeConfig) error {
 	path = cleanPath(path)
+	h := s.wrap(cfg.handler(handler), path, cfg.settings)
 	switch method {
 	case http.MethodGet:
 		s.rtr.GET(path, h)
@@ -125,32 +499,141 @@ func (s *server) Add(handler Handler, method, path string, settings ...ResponseS
 	case http.MethodDelete:
 		s.rtr.DELETE(path, h)
 	case http.MethodOptions:
-		s.rtr.OPTIONS(path, h)
+		return s.handleOptions(path, h, false)
 	default:
 		return fmt.Errorf("method %s is not supported", method)
 	}
+	s.methods[path] = append(s.methods[path], method)
+	allowed := s.allowed(path)
+	if cfg.cors != nil {
+		allowed = cfg.cors.handler(allowed)
+	}
+	auto := s.wrap(allowed, path, cfg.settings)
+	return s.handleOptions(path, auto, true)
+}
+
+// handleOptions registers the OPTIONS handler for the given path. An automatic
+// handler never replaces an existing one, while an added handler replaces an
+// automatic one.
+func (s *server) handleOptions(path string, h httprouter.Handle, auto bool) error {
+	route, ok := s.options[path]
+	switch {
+	case !ok:
+		route = &optionsRoute{handle: h, auto: auto}
+		s.options[path] = route
+		s.rtr.OPTIONS(path, func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+			route.handle(w, r, p)
+		})
+	case auto:
+	case route.auto:
+		route.handle = h
+		route.auto = false
+	default:
+		return fmt.Errorf("OPTIONS handler for %s already exists", path)
+	}
 	return nil
 }
 
-func (s *server) SetTlsConfiguration(enabled bool, cfg *tls.Config) {
-	s.tlsEnabled = enabled
-	s.tlsConfig = cfg
+// allowed returns a handler responding to OPTIONS requests with the methods
+// allowed for the given path.
+func (s *server) allowed(path string) Handler {
+	return func(w http.ResponseWriter, r *http.Request, p Parameters) {
+		methods := append([]string{}, s.methods[path]...)
+		methods = append(methods, http.MethodOptions)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// wrap returns the handler as a httprouter.Handle; tracking the request and
+// applying the response settings before the handler is called.
+func (s *server) wrap(handler Handler, path string, settings []ResponseSetting) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		s.wg.Add(1)
+		defer s.wg.Done()
+		recordRoute(r.Context(), path)
+		if s.metrics != nil {
+			s.metrics.inFlight.Inc()
+			defer s.metrics.inFlight.Dec()
+			rw := newResponseWriter(w)
+			defer s.metrics.observe(path, r.Method, rw, time.Now())
+			w = rw
+		}
+		if atomic.LoadInt32(&s.run) < 1 {
+			w.Header().Set("Connection", "close")
+			JsonResponse(w, Error{
+				Code:    ErrServiceUnavailableCode,
+				Message: "Service is unavailable",
+			}, http.StatusServiceUnavailable)
+			return
+		}
+		ctx := context.WithValue(r.Context(), routePatternKey, path)
+		if id := clientIdentity(r.TLS); id != nil {
+			ctx = context.WithValue(ctx, clientIdentityKey, id)
+		}
+		r = r.WithContext(ctx)
+		applyResponseSettings(w, settings)
+		handler(w, r, parameters(p))
+	}
+}
+
+// handler returns the server's HTTP handler.
+func (s *server) handler() http.Handler {
+	var h http.Handler = s.rtr
+	if s.accessLog != nil {
+		h = accessLog(*s.accessLog, h)
+	}
+	return requestId(h)
 }
 
 // JsonResponse encodes and writes a JSON response using the given data object.
+// ValidationErrors are written as an Error with the failures as its details.
+// Errors are written with the ID of the request being responded to. When
+// problem details are enabled, Errors are written as application/problem+json.
 func JsonResponse(w http.ResponseWriter, data interface{}, status int) {
+	if errs, ok := data.(ValidationErrors); ok {
+		data = errs.Response()
+	}
+	contentType := "application/json"
+	if ProblemJsonEnabled() {
+		if e, ok := asError(data); ok {
+			data = e.Problem(status)
+			contentType = ProblemJsonContentType
+		}
+	}
+	data = withRequestId(w, data)
 	b, err := json.Marshal(data)
 	if err != nil {
 		http.Error(w, "failed to create JSON response", http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(status)
 	fmt.Fprintf(w, "%s", b)
 }
 
+// ErrorResponse writes the error as a JSON response using the canonical HTTP
+// status of its code. Errors that are neither an Error nor ValidationErrors are
+// not exposed to the client; a generic processing error is written instead.
+func ErrorResponse(w http.ResponseWriter, err error) {
+	var errs ValidationErrors
+	var e Error
+	switch {
+	case errors.As(err, &errs):
+		e = errs.Response()
+	case errors.As(err, &e):
+	default:
+		e = Error{
+			Code:    ErrProcessingRequestCode,
+			Message: "Failed to process request",
+		}
+	}
+	JsonResponse(w, e, e.Status())
+}
+
 // router sets up a new httprouter.Router with predefined handlers for panics,
-// resource not found, and method not allowed.
+// resource not found, and method not allowed. The router sets the Allow header
+// before calling the method not allowed handler.
 func router() *httprouter.Router {
 	rtr := httprouter.New()
 	rtr.PanicHandler = func(w http.ResponseWriter, r *http.Request, err interface{}) {
@@ -164,13 +647,13 @@ func router() *httprouter.Router {
 		JsonResponse(w, Error{
 			Code:    ErrNotFoundCode,
 			Message: "Failed to find resource",
-		}, http.StatusInternalServerError)
+		}, http.StatusNotFound)
 	})
 	rtr.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		JsonResponse(w, Error{
 			Code:    ErrNotAllowedCode,
 			Message: "Method not allowed",
-		}, http.StatusInternalServerError)
+		}, http.StatusMethodNotAllowed)
 	})
 	return rtr
 }
@@ -1,11 +1,14 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/julienschmidt/httprouter"
 	"github.com/stretchr/testify/require"
 )
 
@@ -27,3 +30,248 @@ func TestCleanPath(t *testing.T) {
 	p = "hello/world/"
 	require.Equal(t, "hello/world", cleanPath(p))
 }
+
+func TestServerAddMiddleware(t *testing.T) {
+	order := []string{}
+	mw := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w http.ResponseWriter, r *http.Request, p Parameters) {
+				order = append(order, name)
+				next(w, r, p)
+			}
+		}
+	}
+	s := New(":8080", 10, 10).(*server)
+	s.run = 1
+	s.Use(mw("global"))
+	err := s.Handle(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			order = append(order, "handler")
+			w.WriteHeader(http.StatusOK)
+		},
+		http.MethodGet, "/hello/",
+		mw("route"),
+		SetResponseHeader("auth", "bearer"),
+	)
+	require.Nil(t, err)
+	r, err := http.NewRequest(http.MethodGet, "/hello", nil)
+	require.Nil(t, err)
+	rr := httptest.NewRecorder()
+	s.rtr.ServeHTTP(rr, r)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "bearer", rr.Header().Get("auth"))
+	require.Equal(t, []string{"global", "route", "handler"}, order)
+}
+
+func TestServerAddUnsupportedMethod(t *testing.T) {
+	s := New(":8080", 10, 10)
+	err := s.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {},
+		http.MethodTrace, "/hello",
+	)
+	require.NotNil(t, err)
+}
+
+func TestRouterNotFound(t *testing.T) {
+	rtr := router()
+	r, err := http.NewRequest(http.MethodGet, "/missing", nil)
+	require.Nil(t, err)
+	rr := httptest.NewRecorder()
+	rtr.ServeHTTP(rr, r)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	rtr := router()
+	rtr.GET("/hello", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {})
+	rtr.PUT("/hello", func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {})
+	r, err := http.NewRequest(http.MethodPost, "/hello", nil)
+	require.Nil(t, err)
+	rr := httptest.NewRecorder()
+	rtr.ServeHTTP(rr, r)
+	require.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	allow := rr.Header().Get("Allow")
+	require.Contains(t, allow, http.MethodGet)
+	require.Contains(t, allow, http.MethodPut)
+}
+
+func TestServerAddOptions(t *testing.T) {
+	s := New(":8080", 10, 10).(*server)
+	s.run = 1
+	handler := func(w http.ResponseWriter, r *http.Request, p Parameters) {
+		w.WriteHeader(http.StatusOK)
+	}
+	require.Nil(t, s.Add(handler, http.MethodGet, "/hello"))
+	require.Nil(t, s.Add(handler, http.MethodPost, "/hello"))
+	r, err := http.NewRequest(http.MethodOptions, "/hello", nil)
+	require.Nil(t, err)
+	rr := httptest.NewRecorder()
+	s.rtr.ServeHTTP(rr, r)
+	require.Equal(t, http.StatusNoContent, rr.Code)
+	require.Equal(t, "GET, POST, OPTIONS", rr.Header().Get("Allow"))
+
+	require.Nil(t, s.Add(handler, http.MethodOptions, "/hello"))
+	rr = httptest.NewRecorder()
+	s.rtr.ServeHTTP(rr, r)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NotNil(t, s.Add(handler, http.MethodOptions, "/hello"))
+	require.Nil(t, s.Add(handler, http.MethodPut, "/hello"))
+}
+
+func TestServerUnavailable(t *testing.T) {
+	called := false
+	s := New(":8080", 10, 10).(*server)
+	err := s.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			called = true
+			w.WriteHeader(http.StatusOK)
+		},
+		http.MethodGet, "/hello",
+	)
+	require.Nil(t, err)
+	r, err := http.NewRequest(http.MethodGet, "/hello", nil)
+	require.Nil(t, err)
+	rr := httptest.NewRecorder()
+	s.rtr.ServeHTTP(rr, r)
+	require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	require.Equal(t, "close", rr.Header().Get("Connection"))
+	require.False(t, called)
+}
+
+func TestServerStop(t *testing.T) {
+	addr := freeAddress(t)
+	events := make(chan string, 4)
+	hook := func(name string) StopHook {
+		return func(ctx context.Context) error {
+			events <- name
+			return nil
+		}
+	}
+	s := New(addr, 10, 10,
+		WithBeforeStop(hook("before")),
+		WithAfterStop(hook("after")),
+	)
+	started := make(chan struct{})
+	require.Nil(t, s.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			close(started)
+			time.Sleep(100 * time.Millisecond)
+			events <- "handler"
+			w.WriteHeader(http.StatusOK)
+		},
+		http.MethodGet, "/slow",
+	))
+	require.Nil(t, s.Start())
+
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		results <- result{resp, err}
+	}()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.Nil(t, s.Stop(ctx))
+	res := <-results
+	require.Nil(t, res.err)
+	defer res.resp.Body.Close()
+	require.Equal(t, http.StatusOK, res.resp.StatusCode)
+	require.True(t, res.resp.Close)
+	close(events)
+	order := []string{}
+	for e := range events {
+		order = append(order, e)
+	}
+	require.Equal(t, []string{"before", "handler", "after"}, order)
+}
+
+func TestServerStopDeadline(t *testing.T) {
+	addr := freeAddress(t)
+	s := New(addr, 10, 10)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	require.Nil(t, s.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			close(started)
+			<-release
+		},
+		http.MethodGet, "/blocked",
+	))
+	require.Nil(t, s.Start())
+	go http.Get("http://" + addr + "/blocked")
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, s.Stop(ctx), context.DeadlineExceeded)
+}
+
+func TestServerReload(t *testing.T) {
+	buf := captureLog(t)
+	addr := freeAddress(t)
+	hooks := 0
+	hook := func(ctx context.Context) error {
+		hooks++
+		return nil
+	}
+	s := New(addr, 10, 10, WithBeforeStop(hook), WithAfterStop(hook)).(*server)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	require.Nil(t, s.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			close(started)
+			<-release
+		},
+		http.MethodGet, "/blocked",
+	))
+	require.Nil(t, s.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			w.WriteHeader(http.StatusOK)
+		},
+		http.MethodGet, "/hello",
+	))
+	require.Nil(t, s.Start())
+	go http.Get("http://" + addr + "/blocked")
+	<-started
+
+	// The server restarts even if in-flight requests do not drain
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.Nil(t, s.reload(ctx))
+	require.Contains(t, buf.String(), "failed to drain requests before reloading")
+	resp, err := http.Get("http://" + addr + "/hello")
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 0, hooks)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, s.Stop(ctx), context.DeadlineExceeded)
+	require.Equal(t, 2, hooks)
+}
+
+func TestServerAddRoute(t *testing.T) {
+	route := Route{
+		Handler: func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			w.WriteHeader(http.StatusOK)
+		},
+		Method:           http.MethodGet,
+		Path:             "/hello",
+		ResponseSettings: []ResponseSetting{SetResponseHeader("auth", "bearer")},
+	}
+	s := New(":8080", 10, 10).(*server)
+	s.run = 1
+	require.Nil(t, s.Add(route.Handler, route.Method, route.Path, route.ResponseSettings...))
+	r, err := http.NewRequest(http.MethodGet, "/hello", nil)
+	require.Nil(t, err)
+	rr := httptest.NewRecorder()
+	s.rtr.ServeHTTP(rr, r)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "bearer", rr.Header().Get("auth"))
+}
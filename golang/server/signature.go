@@ -0,0 +1,252 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/crossedbot/common/golang/crypto"
+)
+
+const (
+	// SignatureKeyIdHeader is the header carrying the ID of the signing key.
+	SignatureKeyIdHeader = "X-Signature-Key-Id"
+
+	// SignatureTimestampHeader is the header carrying the signing time in
+	// seconds since the Unix epoch.
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+
+	// SignatureNonceHeader is the header carrying the request's nonce.
+	SignatureNonceHeader = "X-Signature-Nonce"
+
+	// SignatureHeader is the header carrying the hex encoded signature.
+	SignatureHeader = "X-Signature"
+
+	// SignatureNonceLength is the length of generated nonces.
+	SignatureNonceLength = 24
+
+	// DefaultSignatureWindow is the default maximum difference between a
+	// request's timestamp and the server's time.
+	DefaultSignatureWindow = 5 * time.Minute
+)
+
+var (
+	ErrSignatureMissing   = errors.New("signature is missing")
+	ErrSignatureInvalid   = errors.New("signature is invalid")
+	ErrSignatureTimestamp = errors.New("signature timestamp is outside the allowed window")
+	ErrSignatureReplayed  = errors.New("signature nonce has already been used")
+	ErrSignatureUnknownId = errors.New("signature key ID is unknown")
+)
+
+// SecretLookup returns the shared secret for the given key ID.
+type SecretLookup func(keyId string) ([]byte, error)
+
+// SignatureKeyId returns the key ID of a shared secret; the hex encoded
+// crypto.KeyId of the secret.
+func SignatureKeyId(secret []byte) string {
+	return hex.EncodeToString(crypto.KeyId(secret))
+}
+
+// SecretsByKeyId returns a secret lookup for the given secrets, identified by
+// their SignatureKeyId.
+func SecretsByKeyId(secrets ...[]byte) SecretLookup {
+	byId := make(map[string][]byte, len(secrets))
+	for _, secret := range secrets {
+		byId[SignatureKeyId(secret)] = secret
+	}
+	return func(keyId string) ([]byte, error) {
+		secret, ok := byId[keyId]
+		if !ok {
+			return nil, ErrSignatureUnknownId
+		}
+		return secret, nil
+	}
+}
+
+// NonceStore represents a store remembering nonces for a limited time.
+type NonceStore interface {
+	// Remember stores the nonce for the given duration; returning false if
+	// the nonce is already stored.
+	Remember(nonce string, ttl time.Duration) (bool, error)
+}
+
+// memoryNonceStore implements the NonceStore interface in memory.
+type memoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time // expiration times by nonce
+	sweep  time.Time            // time of the next sweep of expired nonces
+	now    func() time.Time
+}
+
+// NewMemoryNonceStore returns a new nonce store holding nonces in memory.
+func NewMemoryNonceStore() NonceStore {
+	return &memoryNonceStore{nonces: map[string]time.Time{}, now: time.Now}
+}
+
+// Remember stores the nonce for the given duration; returning false if the
+// nonce is already stored.
+func (s *memoryNonceStore) Remember(nonce string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.After(s.sweep) {
+		for n, expires := range s.nonces {
+			if now.After(expires) {
+				delete(s.nonces, n)
+			}
+		}
+		s.sweep = now.Add(time.Minute)
+	}
+	if expires, ok := s.nonces[nonce]; ok && !now.After(expires) {
+		return false, nil
+	}
+	s.nonces[nonce] = now.Add(ttl)
+	return true, nil
+}
+
+// SignatureOptions represents the configuration of the signature verification
+// middleware.
+type SignatureOptions struct {
+	// Secrets looks up the shared secret of a key ID.
+	Secrets SecretLookup
+
+	// Window is the maximum difference between a request's timestamp and
+	// the server's time; defaults to DefaultSignatureWindow.
+	Window time.Duration
+
+	// Nonces remembers the nonces of verified requests; defaults to a new
+	// in-memory store.
+	Nonces NonceStore
+
+	// MaxBodyBytes is the maximum size of a signed request body; defaults
+	// to DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+}
+
+// VerifySignature returns a middleware that verifies the HMAC-SHA256 signature
+// of requests. The signature covers the request's method, URI, timestamp,
+// nonce and body, and is computed with the secret of the key ID. Requests with
+// a missing or invalid signature, a timestamp outside of the window, or a
+// previously used nonce are rejected with 401 Unauthorized.
+func VerifySignature(opts SignatureOptions) Middleware {
+	window := opts.Window
+	if window <= 0 {
+		window = DefaultSignatureWindow
+	}
+	nonces := opts.Nonces
+	if nonces == nil {
+		nonces = NewMemoryNonceStore()
+	}
+	maxBytes := opts.MaxBodyBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBodyBytes
+	}
+	verify := func(r *http.Request) error {
+		keyId := r.Header.Get(SignatureKeyIdHeader)
+		timestamp := r.Header.Get(SignatureTimestampHeader)
+		nonce := r.Header.Get(SignatureNonceHeader)
+		sig, err := hex.DecodeString(r.Header.Get(SignatureHeader))
+		if keyId == "" || timestamp == "" || nonce == "" ||
+			err != nil || len(sig) == 0 {
+			return ErrSignatureMissing
+		}
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return ErrSignatureTimestamp
+		}
+		if d := time.Since(time.Unix(ts, 0)); d > window || d < -window {
+			return ErrSignatureTimestamp
+		}
+		secret, err := opts.Secrets(keyId)
+		if err != nil {
+			return err
+		}
+		body, err := readBody(r, maxBytes)
+		if err != nil {
+			return err
+		}
+		expected := signature(secret, r.Method, r.URL.RequestURI(),
+			timestamp, nonce, body)
+		if !hmac.Equal(sig, expected) {
+			return ErrSignatureInvalid
+		}
+		ok, err := nonces.Remember(keyId+":"+nonce, 2*window)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrSignatureReplayed
+		}
+		return nil
+	}
+	return func(next Handler) Handler {
+		return func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			if err := verify(r); err != nil {
+				JsonResponse(w, Error{
+					Code:    ErrUnauthorizedCode,
+					Message: "Invalid request signature",
+				}, http.StatusUnauthorized)
+				return
+			}
+			next(w, r, p)
+		}
+	}
+}
+
+// SignRequest signs the request with the given key ID and shared secret by
+// setting its signature headers.
+func SignRequest(r *http.Request, keyId string, secret []byte) error {
+	nonce, err := crypto.GenerateRandomString(SignatureNonceLength)
+	if err != nil {
+		return err
+	}
+	body, err := readBody(r, -1)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := signature(secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	r.Header.Set(SignatureKeyIdHeader, keyId)
+	r.Header.Set(SignatureTimestampHeader, timestamp)
+	r.Header.Set(SignatureNonceHeader, nonce)
+	r.Header.Set(SignatureHeader, hex.EncodeToString(sig))
+	return nil
+}
+
+// signature returns the HMAC-SHA256 signature of the canonical request; the
+// method, URI, timestamp, nonce and hex encoded SHA256 hash of the body
+// separated by newlines.
+func signature(secret []byte, method, uri, timestamp, nonce string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", method, uri, timestamp, nonce,
+		hex.EncodeToString(bodyHash[:]))
+	return mac.Sum(nil)
+}
+
+// readBody reads up to maxBytes of the request body, replacing the body so it
+// can be read again. A negative maxBytes reads the entire body.
+func readBody(r *http.Request, maxBytes int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	var reader io.Reader = r.Body
+	if maxBytes >= 0 {
+		reader = http.MaxBytesReader(nil, r.Body, maxBytes)
+	}
+	body, err := io.ReadAll(reader)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
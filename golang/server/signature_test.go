@@ -0,0 +1,99 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifySignature(t *testing.T) {
+	secret := []byte("secret")
+	keyId := SignatureKeyId(secret)
+	s := New(":8080", 10, 10).(*server)
+	s.run = 1
+	require.Nil(t, s.Handle(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			b, err := io.ReadAll(r.Body)
+			require.Nil(t, err)
+			w.WriteHeader(http.StatusOK)
+			w.Write(b)
+		},
+		http.MethodPost, "/webhook",
+		VerifySignature(SignatureOptions{Secrets: SecretsByKeyId(secret)}),
+	))
+	newRequest := func(body string) *http.Request {
+		r, err := http.NewRequest(http.MethodPost, "/webhook?a=b", strings.NewReader(body))
+		require.Nil(t, err)
+		require.Nil(t, SignRequest(r, keyId, secret))
+		return r
+	}
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		s.handler().ServeHTTP(rr, r)
+		return rr
+	}
+
+	r := newRequest("hello")
+	rr := serve(r)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "hello", rr.Body.String())
+
+	// Replayed requests are rejected
+	r.Body = io.NopCloser(strings.NewReader("hello"))
+	require.Equal(t, http.StatusUnauthorized, serve(r).Code)
+
+	// Tampered bodies are rejected
+	r = newRequest("hello")
+	r.Body = io.NopCloser(strings.NewReader("goodbye"))
+	require.Equal(t, http.StatusUnauthorized, serve(r).Code)
+
+	// Stale timestamps are rejected
+	r = newRequest("hello")
+	stale := time.Now().Add(-time.Hour).Unix()
+	r.Header.Set(SignatureTimestampHeader, strconv.FormatInt(stale, 10))
+	require.Equal(t, http.StatusUnauthorized, serve(r).Code)
+
+	// Unknown keys are rejected
+	r, err := http.NewRequest(http.MethodPost, "/webhook", strings.NewReader("hello"))
+	require.Nil(t, err)
+	require.Nil(t, SignRequest(r, SignatureKeyId([]byte("other")), []byte("other")))
+	require.Equal(t, http.StatusUnauthorized, serve(r).Code)
+
+	// Unsigned requests are rejected
+	r, err = http.NewRequest(http.MethodPost, "/webhook", nil)
+	require.Nil(t, err)
+	require.Equal(t, http.StatusUnauthorized, serve(r).Code)
+}
+
+func TestSecretsByKeyId(t *testing.T) {
+	secret := []byte("secret")
+	lookup := SecretsByKeyId(secret)
+	actual, err := lookup(SignatureKeyId(secret))
+	require.Nil(t, err)
+	require.Equal(t, secret, actual)
+	_, err = lookup("unknown")
+	require.Equal(t, ErrSignatureUnknownId, err)
+	require.Equal(t, 32, len(SignatureKeyId(secret)))
+}
+
+func TestMemoryNonceStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryNonceStore().(*memoryNonceStore)
+	store.now = func() time.Time { return now }
+	ok, err := store.Remember("a", time.Minute)
+	require.Nil(t, err)
+	require.True(t, ok)
+	ok, err = store.Remember("a", time.Minute)
+	require.Nil(t, err)
+	require.False(t, ok)
+	now = now.Add(2 * time.Minute)
+	ok, err = store.Remember("a", time.Minute)
+	require.Nil(t, err)
+	require.True(t, ok)
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// DefaultStaticIndex is the default index file of a static directory.
+const DefaultStaticIndex = "index.html"
+
+// StaticOptions represents the configuration of static file serving.
+type StaticOptions struct {
+	// Index is the file served for directories and by the SPA fallback;
+	// defaults to DefaultStaticIndex.
+	Index string
+
+	// CacheControl is the Cache-Control header per file extension, e.g.
+	// ".js": "public, max-age=31536000, immutable".
+	CacheControl map[string]string
+
+	// DefaultCacheControl is the Cache-Control header of files whose
+	// extension is not in CacheControl; no header is set if empty.
+	DefaultCacheControl string
+
+	// Precompressed serves the gzip compressed variant of a file, the file
+	// with a ".gz" extension, if it exists and the client accepts gzip.
+	Precompressed bool
+
+	// SpaFallback serves the index file, for single-page applications, when
+	// a file is not found. Only requests without a file extension that
+	// explicitly accept text/html fall back; all others are not found.
+	SpaFallback bool
+
+	// Exclude are the request path prefixes, e.g. "/api", that never fall
+	// back to the index file.
+	Exclude []string
+}
+
+// staticFiles represents a file system served at a path prefix.
+type staticFiles struct {
+	fsys     fs.FS
+	opts     StaticOptions
+	prefix   string
+	notFound http.Handler
+	etags    sync.Map // ETags by file name, size and modification time
+}
+
+// Static serves the files of the file system, e.g. an embed.FS, at the given
+// path prefix; see StaticOptions. Files are served with ETag and Last-Modified
+// headers, honouring conditional and range requests. Directories are served
+// by their index file and are not listed. Missing files are handled by the
+// server's not found handler.
+//
+// A file system mounted at "/" is served only for GET and HEAD requests not
+// matching any other route.
+func (s *server) Static(prefix string, fsys fs.FS, opts StaticOptions, options ...RouteOption) error {
+	return s.static(prefix, fsys, opts, s.config(options))
+}
+
+// static adds the routes serving the file system at the given path prefix.
+func (s *server) static(prefix string, fsys fs.FS, opts StaticOptions, cfg routeConfig) error {
+	if opts.Index == "" {
+		opts.Index = DefaultStaticIndex
+	}
+	prefix = cleanPath(path.Join("/", prefix))
+	sf := &staticFiles{
+		fsys:     fsys,
+		opts:     opts,
+		prefix:   prefix,
+		notFound: s.rtr.NotFound,
+	}
+	if prefix != "/" {
+		p := path.Join(prefix, "/*filepath")
+		if err := s.handle(sf.serve, http.MethodGet, p, cfg); err != nil {
+			return err
+		}
+		return s.handle(sf.serve, http.MethodHead, p, cfg)
+	}
+	// A catch-all route at the root would conflict with all other routes
+	h := s.wrap(cfg.handler(sf.serve), prefix, cfg.settings)
+	notFound := s.rtr.NotFound
+	s.rtr.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		h(w, r, nil)
+	})
+	return nil
+}
+
+// serve serves the file of the request's path.
+func (sf *staticFiles) serve(w http.ResponseWriter, r *http.Request, p Parameters) {
+	name := strings.TrimPrefix(r.URL.Path, sf.prefix)
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		name = "."
+	}
+	f, fi, name, err := sf.open(name)
+	if err != nil && sf.fallback(r) {
+		f, fi, name, err = sf.open(sf.opts.Index)
+	}
+	if err != nil {
+		sf.notFound.ServeHTTP(w, r)
+		return
+	}
+	defer f.Close()
+	if cc, ok := sf.opts.CacheControl[path.Ext(name)]; ok {
+		w.Header().Set("Cache-Control", cc)
+	} else if sf.opts.DefaultCacheControl != "" {
+		w.Header().Set("Cache-Control", sf.opts.DefaultCacheControl)
+	}
+	file := name
+	if sf.opts.Precompressed {
+		w.Header().Add("Vary", "Accept-Encoding")
+		accepted := negotiateEncoding(
+			r.Header.Get("Accept-Encoding"),
+			[]string{EncodingGzip},
+		)
+		if accepted != "" {
+			if gz, gzfi, gzname, err := sf.open(name + ".gz"); err == nil {
+				defer gz.Close()
+				f, fi, file = gz, gzfi, gzname
+				w.Header().Set("Content-Encoding", EncodingGzip)
+			}
+		}
+	}
+	content, err := readSeeker(f)
+	var etag string
+	if err == nil {
+		etag, err = sf.etag(file, fi, content)
+	}
+	if err != nil {
+		w.Header().Del("Content-Encoding")
+		JsonResponse(w, Error{
+			Code:    ErrProcessingRequestCode,
+			Message: "Failed to read file",
+		}, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	// The content type is determined by the name of the uncompressed file
+	http.ServeContent(w, r, name, fi.ModTime(), content)
+}
+
+// open opens the named file, or the index file of the named directory;
+// returning the file, its information and its name.
+func (sf *staticFiles) open(name string) (fs.File, fs.FileInfo, string, error) {
+	for i := 0; i < 2; i++ {
+		f, err := sf.fsys.Open(name)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, nil, "", err
+		}
+		if !fi.IsDir() {
+			return f, fi, name, nil
+		}
+		f.Close()
+		name = path.Join(name, sf.opts.Index)
+	}
+	return nil, nil, "", fs.ErrNotExist
+}
+
+// fallback returns true if the request falls back to the index file.
+func (sf *staticFiles) fallback(r *http.Request) bool {
+	if !sf.opts.SpaFallback || path.Ext(r.URL.Path) != "" {
+		return false
+	}
+	for _, prefix := range sf.opts.Exclude {
+		prefix = cleanPath(prefix)
+		if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
+			return false
+		}
+	}
+	for _, a := range parseAccept(r.Header.Get("Accept")) {
+		if a.mediaType == "text/html" && a.quality > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// etag returns the strong ETag of the named file's content; cached by the
+// file's name, size and modification time.
+func (sf *staticFiles) etag(name string, fi fs.FileInfo, content io.ReadSeeker) (string, error) {
+	key := fmt.Sprintf("%s:%d:%d", name, fi.Size(), fi.ModTime().UnixNano())
+	if etag, ok := sf.etags.Load(key); ok {
+		return etag.(string), nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := etagOf(h.Sum(nil))
+	sf.etags.Store(key, etag)
+	return etag, nil
+}
+
+// readSeeker returns the file as an io.ReadSeeker; reading it into memory if
+// it is not seekable.
+func readSeeker(f fs.File) (io.ReadSeeker, error) {
+	if rs, ok := f.(io.ReadSeeker); ok {
+		return rs, nil
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
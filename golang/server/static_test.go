@@ -0,0 +1,141 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var staticModTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func staticFs() fstest.MapFS {
+	return fstest.MapFS{
+		"index.html":       {Data: []byte("<html>index</html>"), ModTime: staticModTime},
+		"app.js":           {Data: []byte("console.log('app')"), ModTime: staticModTime},
+		"app.js.gz":        {Data: []byte("gzipped"), ModTime: staticModTime},
+		"docs/index.html":  {Data: []byte("<html>docs</html>"), ModTime: staticModTime},
+		"images/empty.txt": {Data: []byte("empty"), ModTime: staticModTime},
+	}
+}
+
+func serveStatic(s *server, method, target string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	s.handler().ServeHTTP(rr, r)
+	return rr
+}
+
+func TestStatic(t *testing.T) {
+	s := New(":8080", 10, 10).(*server)
+	s.run = 1
+	require.Nil(t, s.Static("/ui", staticFs(), StaticOptions{
+		CacheControl:        map[string]string{".js": "public, max-age=31536000"},
+		DefaultCacheControl: "no-cache",
+	}))
+
+	rr := serveStatic(s, http.MethodGet, "/ui/app.js", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "console.log('app')", rr.Body.String())
+	require.Contains(t, rr.Header().Get("Content-Type"), "javascript")
+	require.Equal(t, "public, max-age=31536000", rr.Header().Get("Cache-Control"))
+	require.Equal(t, staticModTime.Format(http.TimeFormat), rr.Header().Get("Last-Modified"))
+	etag := rr.Header().Get("ETag")
+	require.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+
+	rr = serveStatic(s, http.MethodGet, "/ui/app.js", map[string]string{"If-None-Match": etag})
+	require.Equal(t, http.StatusNotModified, rr.Code)
+	rr = serveStatic(s, http.MethodGet, "/ui/app.js", map[string]string{
+		"If-Modified-Since": staticModTime.Format(http.TimeFormat),
+	})
+	require.Equal(t, http.StatusNotModified, rr.Code)
+
+	rr = serveStatic(s, http.MethodGet, "/ui/app.js", map[string]string{"Range": "bytes=0-6"})
+	require.Equal(t, http.StatusPartialContent, rr.Code)
+	require.Equal(t, "console", rr.Body.String())
+
+	rr = serveStatic(s, http.MethodHead, "/ui/app.js", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Empty(t, rr.Body.String())
+
+	rr = serveStatic(s, http.MethodGet, "/ui/", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "<html>index</html>", rr.Body.String())
+	require.Equal(t, "no-cache", rr.Header().Get("Cache-Control"))
+	rr = serveStatic(s, http.MethodGet, "/ui/docs", nil)
+	require.Equal(t, "<html>docs</html>", rr.Body.String())
+
+	// Directories without an index are not listed
+	rr = serveStatic(s, http.MethodGet, "/ui/images/", nil)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+	rr = serveStatic(s, http.MethodGet, "/ui/../server.go", nil)
+	require.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestStaticPrecompressed(t *testing.T) {
+	s := New(":8080", 10, 10).(*server)
+	s.run = 1
+	require.Nil(t, s.Group("/assets").Static("/", staticFs(), StaticOptions{
+		Precompressed: true,
+	}))
+	rr := serveStatic(s, http.MethodGet, "/assets/app.js", map[string]string{
+		"Accept-Encoding": "gzip",
+	})
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, EncodingGzip, rr.Header().Get("Content-Encoding"))
+	require.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+	require.Contains(t, rr.Header().Get("Content-Type"), "javascript")
+	require.Equal(t, "gzipped", rr.Body.String())
+
+	rr = serveStatic(s, http.MethodGet, "/assets/app.js", nil)
+	require.Empty(t, rr.Header().Get("Content-Encoding"))
+	require.Equal(t, "console.log('app')", rr.Body.String())
+}
+
+func TestStaticSpaFallback(t *testing.T) {
+	s := New(":8080", 10, 10).(*server)
+	s.run = 1
+	require.Nil(t, s.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			JsonResponse(w, []string{}, http.StatusOK)
+		},
+		http.MethodGet, "/api/items",
+	))
+	require.Nil(t, s.Static("/", staticFs(), StaticOptions{
+		SpaFallback: true,
+		Exclude:     []string{"/api"},
+	}))
+	html := map[string]string{"Accept": "text/html,application/xhtml+xml,*/*;q=0.8"}
+	json := map[string]string{"Accept": "application/json"}
+
+	rr := serveStatic(s, http.MethodGet, "/api/items", json)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "[]", rr.Body.String())
+
+	rr = serveStatic(s, http.MethodGet, "/app.js", nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr = serveStatic(s, http.MethodGet, "/settings/profile", html)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "<html>index</html>", rr.Body.String())
+
+	for _, tt := range []struct {
+		method  string
+		target  string
+		headers map[string]string
+	}{
+		{http.MethodGet, "/settings/profile", json},
+		{http.MethodGet, "/api/missing", html},
+		{http.MethodGet, "/missing.js", html},
+		{http.MethodPost, "/settings/profile", html},
+	} {
+		rr = serveStatic(s, tt.method, tt.target, tt.headers)
+		require.Equal(t, http.StatusNotFound, rr.Code, tt.target)
+		require.Contains(t, rr.Body.String(), "Failed to find resource", tt.target)
+	}
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/crossedbot/common/golang/crypto"
+	"github.com/crossedbot/common/golang/logger"
+)
+
+// ClientAuth represents the configuration for authenticating clients by their
+// TLS certificates (mutual TLS).
+type ClientAuth struct {
+	// CaFile is the path to a PEM encoded bundle of certificate authorities
+	// used to verify client certificates.
+	CaFile string
+
+	// Required indicates whether clients must present a valid certificate.
+	// Otherwise, a certificate is only verified when one is presented.
+	Required bool
+
+	// AllowedSubjects is a list of allowed certificate subjects, matched
+	// against either the subject's common name or its full distinguished
+	// name. An empty list allows all subjects.
+	AllowedSubjects []string
+
+	// AllowedSubjectAltNames is a list of allowed subject alternative names
+	// (DNS names, email addresses, IP addresses, and URIs). An empty list
+	// allows all names.
+	AllowedSubjectAltNames []string
+}
+
+// ClientIdentity represents the identity of a client verified by its TLS
+// certificate.
+type ClientIdentity struct {
+	Subject         string   // distinguished name of the subject
+	CommonName      string   // common name of the subject
+	SubjectAltNames []string // subject alternative names
+	Fingerprint     string   // SHA256 fingerprint of the public key
+}
+
+// GetClientIdentity returns the verified client identity stored in the
+// context. If the client was not verified, nil is returned.
+func GetClientIdentity(ctx context.Context) *ClientIdentity {
+	id, _ := ctx.Value(clientIdentityKey).(*ClientIdentity)
+	return id
+}
+
+// clientIdentity returns the client identity for the verified connection
+// state. If the connection has no verified certificate, nil is returned.
+func clientIdentity(state *tls.ConnectionState) *ClientIdentity {
+	if state == nil || len(state.VerifiedChains) == 0 ||
+		len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	leaf := state.VerifiedChains[0][0]
+	return &ClientIdentity{
+		Subject:         leaf.Subject.String(),
+		CommonName:      leaf.Subject.CommonName,
+		SubjectAltNames: subjectAltNames(leaf),
+		Fingerprint:     crypto.Fingerprint(leaf.PublicKey),
+	}
+}
+
+// subjectAltNames returns all subject alternative names of the certificate.
+func subjectAltNames(cert *x509.Certificate) []string {
+	names := []string{}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+// clientAuthConfig represents the loaded client authentication configuration.
+type clientAuthConfig struct {
+	auth ClientAuth
+	pool *x509.CertPool
+}
+
+// newClientAuthConfig returns the client authentication configuration for the
+// given settings; loading the certificate authorities from the CA file.
+func newClientAuthConfig(auth ClientAuth) (*clientAuthConfig, error) {
+	b, err := os.ReadFile(auth.CaFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file; %s", err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("failed to parse CA file; no certificates found")
+	}
+	return &clientAuthConfig{auth: auth, pool: pool}, nil
+}
+
+// apply sets the client authentication on the TLS configuration.
+func (c *clientAuthConfig) apply(cfg *tls.Config) {
+	cfg.ClientCAs = c.pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	if c.auth.Required {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	cfg.VerifyConnection = c.verify
+}
+
+// verify checks the verified client certificate of the connection against the
+// allowed subjects and subject alternative names.
+func (c *clientAuthConfig) verify(state tls.ConnectionState) error {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		// An unverified certificate has already been rejected when
+		// one is required
+		return nil
+	}
+	leaf := state.VerifiedChains[0][0]
+	if len(c.auth.AllowedSubjects) > 0 &&
+		!containsAny(c.auth.AllowedSubjects,
+			leaf.Subject.CommonName, leaf.Subject.String()) {
+		return fmt.Errorf("client certificate subject is not allowed")
+	}
+	if len(c.auth.AllowedSubjectAltNames) > 0 &&
+		!containsAny(c.auth.AllowedSubjectAltNames, subjectAltNames(leaf)...) {
+		return fmt.Errorf(
+			"client certificate subject alternative name is not allowed",
+		)
+	}
+	return nil
+}
+
+// containsAny returns true if the list contains any of the given values.
+func containsAny(list []string, values ...string) bool {
+	for _, l := range list {
+		for _, v := range values {
+			if l == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// certificate represents a TLS certificate loaded from a certificate and key
+// file pair, which can be reloaded without restarting the server.
+type certificate struct {
+	certFile string           // path to the PEM encoded certificate
+	keyFile  string           // path to the PEM encoded private key
+	mu       sync.RWMutex     // protects the fields below
+	cert     *tls.Certificate // currently loaded certificate
+	certMod  time.Time        // modification time of the certificate file
+	keyMod   time.Time        // modification time of the key file
+	quit     chan struct{}    // stops watching the files for changes
+}
+
+// newCertificate returns a new certificate loaded from the given certificate
+// and key files.
+func newCertificate(certFile, keyFile string) (*certificate, error) {
+	c := &certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.Load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Load (re)loads the certificate from its files. On failure, the previously
+// loaded certificate remains in use.
+func (c *certificate) Load() error {
+	certMod, keyMod, err := c.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate; %s", err.Error())
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	c.certMod = certMod
+	c.keyMod = keyMod
+	return nil
+}
+
+// GetCertificate returns the currently loaded certificate; it is intended to
+// be used as the tls.Config's GetCertificate function.
+func (c *certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// Watch starts checking the certificate and key files for changes at the
+// given interval, reloading the certificate when either has changed.
+func (c *certificate) Watch(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if interval <= 0 || c.quit != nil {
+		return
+	}
+	c.quit = make(chan struct{})
+	go c.watch(interval, c.quit)
+}
+
+// Unwatch stops checking the certificate and key files for changes.
+func (c *certificate) Unwatch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.quit != nil {
+		close(c.quit)
+		c.quit = nil
+	}
+}
+
+// watch reloads the certificate whenever its files change until quit is
+// closed.
+func (c *certificate) watch(interval time.Duration, quit chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !c.changed() {
+				continue
+			}
+			if err := c.Load(); err != nil {
+				logger.Error(fmt.Sprintf(
+					"server: failed to reload certificate; %s",
+					err.Error(),
+				))
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// changed returns true if the certificate or key file has been modified since
+// the certificate was last loaded.
+func (c *certificate) changed() bool {
+	certMod, keyMod, err := c.modTimes()
+	if err != nil {
+		return false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return !certMod.Equal(c.certMod) || !keyMod.Equal(c.keyMod)
+}
+
+// modTimes returns the modification times of the certificate and key files.
+func (c *certificate) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/crossedbot/common/golang/crypto"
+)
+
+// writeCertificate writes a self-signed certificate and key for the given
+// common name to the directory; returning their file paths.
+func writeCertificate(t *testing.T, dir, cn string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.Nil(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	require.Nil(t, err)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	require.Nil(t, os.WriteFile(certFile, certPem, 0600))
+	require.Nil(t, os.WriteFile(keyFile, keyPem, 0600))
+	return certFile, keyFile
+}
+
+// freeAddress returns an available local TCP address.
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// commonName returns the subject common name of the certificate.
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.Nil(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestCertificateLoad(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "first")
+	c, err := newCertificate(certFile, keyFile)
+	require.Nil(t, err)
+	cert, err := c.GetCertificate(nil)
+	require.Nil(t, err)
+	require.Equal(t, "first", commonName(t, cert))
+
+	writeCertificate(t, dir, "second")
+	require.Nil(t, c.Load())
+	cert, err = c.GetCertificate(nil)
+	require.Nil(t, err)
+	require.Equal(t, "second", commonName(t, cert))
+
+	_, err = newCertificate(filepath.Join(dir, "missing.pem"), keyFile)
+	require.NotNil(t, err)
+}
+
+func TestCertificateWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "first")
+	c, err := newCertificate(certFile, keyFile)
+	require.Nil(t, err)
+	c.Watch(10 * time.Millisecond)
+	defer c.Unwatch()
+
+	writeCertificate(t, dir, "second")
+	later := time.Now().Add(time.Second)
+	require.Nil(t, os.Chtimes(certFile, later, later))
+	require.Eventually(t, func() bool {
+		cert, _ := c.GetCertificate(nil)
+		return commonName(t, cert) == "second"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServerTls(t *testing.T) {
+	certFile, keyFile := writeCertificate(t, t.TempDir(), "localhost")
+	addr := freeAddress(t)
+	s := New(addr, 10, 10)
+	require.Nil(t, s.SetTlsCertificate(certFile, keyFile, 0))
+	require.Nil(t, s.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			w.WriteHeader(http.StatusOK)
+		},
+		http.MethodGet, "/hello",
+	))
+	require.Nil(t, s.Start())
+	defer s.Stop(context.Background())
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	resp, err := client.Get("https://" + addr + "/hello")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotNil(t, resp.TLS)
+}
+
+func TestServerTlsReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "first")
+	addr := freeAddress(t)
+	s := New(addr, 10, 10)
+	require.Nil(t, s.SetTlsCertificate(certFile, keyFile, 0))
+	require.Nil(t, s.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			w.WriteHeader(http.StatusOK)
+		},
+		http.MethodGet, "/hello",
+	))
+	require.Nil(t, s.Start())
+	defer s.Stop(context.Background())
+	newClient := func() *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}}
+	}
+	get := func(client *http.Client) (string, bool) {
+		reused := false
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				reused = info.Reused
+			},
+		}
+		r, err := http.NewRequest(http.MethodGet, "https://"+addr+"/hello", nil)
+		require.Nil(t, err)
+		r = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+		resp, err := client.Do(r)
+		require.Nil(t, err)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return resp.TLS.PeerCertificates[0].Subject.CommonName, reused
+	}
+	client := newClient()
+	cn, _ := get(client)
+	require.Equal(t, "first", cn)
+
+	// Established connections are kept, while new ones use the new
+	// certificate
+	writeCertificate(t, dir, "second")
+	require.Nil(t, s.Reload())
+	cn, reused := get(client)
+	require.Equal(t, "first", cn)
+	require.True(t, reused)
+	cn, _ = get(newClient())
+	require.Equal(t, "second", cn)
+}
+
+func TestServerTlsConfiguration(t *testing.T) {
+	s := New(":8080", 10, 10).(*server)
+	s.SetTlsConfiguration(true, nil)
+	_, err := s.tlsConfiguration()
+	require.NotNil(t, err)
+	require.NotNil(t, s.Start())
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS13}
+	s.SetTlsConfiguration(true, cfg)
+	actual, err := s.tlsConfiguration()
+	require.Nil(t, err)
+	require.Equal(t, uint16(tls.VersionTLS13), actual.MinVersion)
+	require.Nil(t, actual.GetCertificate)
+}
+
+func TestServerTlsClientAuth(t *testing.T) {
+	certFile, keyFile := writeCertificate(t, t.TempDir(), "localhost")
+	clientCertFile, clientKeyFile := writeCertificate(t, t.TempDir(), "client")
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	require.Nil(t, err)
+	addr := freeAddress(t)
+	s := New(addr, 10, 10)
+	require.Nil(t, s.SetTlsCertificate(certFile, keyFile, 0))
+	require.Nil(t, s.SetTlsClientAuth(ClientAuth{
+		CaFile:                 clientCertFile,
+		Required:               true,
+		AllowedSubjects:        []string{"client"},
+		AllowedSubjectAltNames: []string{"localhost"},
+	}))
+	require.Nil(t, s.Add(
+		func(w http.ResponseWriter, r *http.Request, p Parameters) {
+			id := GetClientIdentity(r.Context())
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, id.CommonName+" "+id.Fingerprint)
+		},
+		http.MethodGet, "/hello",
+	))
+	require.Nil(t, s.Start())
+	defer s.Stop(context.Background())
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       []tls.Certificate{clientCert},
+		},
+	}}
+	resp, err := client.Get("https://" + addr + "/hello")
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	leaf, err := x509.ParseCertificate(clientCert.Certificate[0])
+	require.Nil(t, err)
+	b, err := io.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.Equal(t, "client "+crypto.Fingerprint(leaf.PublicKey), string(b))
+
+	anonymous := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	_, err = anonymous.Get("https://" + addr + "/hello")
+	require.NotNil(t, err)
+}
+
+func TestClientAuthConfigVerify(t *testing.T) {
+	certFile, keyFile := writeCertificate(t, t.TempDir(), "client")
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.Nil(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.Nil(t, err)
+	state := tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{leaf}},
+	}
+	cfg, err := newClientAuthConfig(ClientAuth{
+		CaFile:          certFile,
+		AllowedSubjects: []string{"other"},
+	})
+	require.Nil(t, err)
+	require.NotNil(t, cfg.verify(state))
+	require.Nil(t, cfg.verify(tls.ConnectionState{}))
+
+	cfg.auth.AllowedSubjects = []string{"CN=client"}
+	require.Nil(t, cfg.verify(state))
+	cfg.auth.AllowedSubjectAltNames = []string{"example.com"}
+	require.NotNil(t, cfg.verify(state))
+	cfg.auth.AllowedSubjectAltNames = []string{"127.0.0.1"}
+	require.Nil(t, cfg.verify(state))
+
+	_, err = newClientAuthConfig(ClientAuth{CaFile: keyFile})
+	require.NotNil(t, err)
+}
+
+func TestGetClientIdentity(t *testing.T) {
+	require.Nil(t, GetClientIdentity(context.Background()))
+	expected := &ClientIdentity{CommonName: "client"}
+	ctx := context.WithValue(context.Background(), clientIdentityKey, expected)
+	require.Equal(t, expected, GetClientIdentity(ctx))
+}
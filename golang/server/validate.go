@@ -0,0 +1,387 @@
+package server
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ValidationTag is the struct tag containing a field's validation rules, e.g.
+// `validate:"required,min=1,max=64"`. Rules are separated by commas, except
+// the regexp rule, which consumes the remainder of the tag.
+//
+// Supported rules are:
+//   - omitempty: skips the remaining rules if the value is empty
+//   - required: the value must not be empty
+//   - min=n, max=n: numbers must be at least, or at most, n; strings, slices
+//     and maps must have at least, or at most, n elements
+//   - len=n: strings, slices and maps must have exactly n elements
+//   - regexp=pattern: strings must match the regular expression
+//   - oneof=a b c: the value must be one of the space separated values
+//   - email: strings must be an email address
+//   - uuid: strings must be a UUID
+//   - dive: applies the remaining rules to each element of a slice, array or
+//     map
+//
+// Nested structs, and structs contained in slices, arrays and maps, are
+// validated recursively.
+const ValidationTag = "validate"
+
+// uuidPattern matches a UUID in its canonical textual representation.
+var uuidPattern = regexp.MustCompile(
+	`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`,
+)
+
+// patterns caches compiled regexp rules.
+var patterns sync.Map
+
+// ValidationError represents a failed validation rule of a single field.
+type ValidationError struct {
+	Field   string // path of the field, e.g. "items[0].name"
+	Rule    string // name of the failed rule
+	Message string // description of the failure
+}
+
+// Error formats a validation error as a string.
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationErrors represents a list of validation errors.
+type ValidationErrors []ValidationError
+
+// Error formats the validation errors as a string.
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Response returns the validation errors as an error response.
+func (e ValidationErrors) Response() Error {
+	details := make([]ErrorDetail, len(e))
+	for i, err := range e {
+		details[i] = ErrorDetail{
+			Field:   err.Field,
+			Rule:    err.Rule,
+			Message: err.Message,
+		}
+	}
+	return Error{
+		Code:    ErrValidationCode,
+		Message: "Validation failed",
+		Details: details,
+	}
+}
+
+// Validate validates the given struct, or pointer to a struct, using the rules
+// of its fields' validation tags. If any rule fails, ValidationErrors is
+// returned. Any other error indicates an invalid rule.
+func Validate(v interface{}) error {
+	errs := ValidationErrors{}
+	if err := validateValue(reflect.ValueOf(v), "", nil, &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// rule represents a single parsed validation rule.
+type rule struct {
+	name  string
+	param string
+}
+
+// parseRules parses the rules of a validation tag.
+func parseRules(tag string) []rule {
+	rules := []rule{}
+	for tag != "" {
+		part := tag
+		if strings.HasPrefix(tag, "regexp=") {
+			tag = ""
+		} else if i := strings.Index(tag, ","); i >= 0 {
+			part, tag = tag[:i], tag[i+1:]
+		} else {
+			tag = ""
+		}
+		name, param, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			rules = append(rules, rule{name: name, param: param})
+		}
+	}
+	return rules
+}
+
+// validateValue applies the rules to the value at the given path and validates
+// any nested values.
+func validateValue(v reflect.Value, path string, rules []rule, errs *ValidationErrors) error {
+	for i, r := range rules {
+		switch r.name {
+		case "dive":
+			return validateElements(v, path, rules[i+1:], errs)
+		case "omitempty":
+			if isEmpty(v) {
+				return nil
+			}
+			continue
+		case "required":
+			if isEmpty(v) {
+				*errs = append(*errs, ValidationError{
+					Field:   path,
+					Rule:    r.name,
+					Message: "is required",
+				})
+				return nil
+			}
+			continue
+		}
+		msg, err := checkRule(indirect(v), r)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err.Error())
+		}
+		if msg != "" {
+			*errs = append(*errs, ValidationError{
+				Field:   path,
+				Rule:    r.name,
+				Message: msg,
+			})
+		}
+	}
+	return validateNested(v, path, errs)
+}
+
+// validateNested validates the fields of a struct, and the elements of slices,
+// arrays and maps.
+func validateNested(v reflect.Value, path string, errs *ValidationErrors) error {
+	v = indirect(v)
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get(ValidationTag)
+			if !f.IsExported() || tag == "-" {
+				continue
+			}
+			err := validateValue(
+				v.Field(i), joinFieldPath(path, fieldName(f)),
+				parseRules(tag), errs,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if hasNested(v.Type().Elem()) {
+			return validateElements(v, path, nil, errs)
+		}
+	}
+	return nil
+}
+
+// validateElements applies the rules to each element of a slice, array or map.
+func validateElements(v reflect.Value, path string, rules []rule, errs *ValidationErrors) error {
+	v = indirect(v)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			if err := validateValue(v.Index(i), p, rules, errs); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			p := fmt.Sprintf("%s[%v]", path, iter.Key().Interface())
+			if err := validateValue(iter.Value(), p, rules, errs); err != nil {
+				return err
+			}
+		}
+	case reflect.Invalid:
+	default:
+		return fmt.Errorf("%s: dive requires a slice, array or map", path)
+	}
+	return nil
+}
+
+// checkRule applies the rule to the value; returning a failure message if the
+// value is invalid, or an error if the rule is.
+func checkRule(v reflect.Value, r rule) (string, error) {
+	if !v.IsValid() {
+		// Nil pointers and interfaces are only checked by required
+		return "", nil
+	}
+	switch r.name {
+	case "min", "max", "len":
+		return checkBound(v, r)
+	case "regexp":
+		s, err := stringValue(v, r)
+		if err != nil {
+			return "", err
+		}
+		re, err := compilePattern(r.param)
+		if err != nil {
+			return "", err
+		}
+		if !re.MatchString(s) {
+			return fmt.Sprintf("must match pattern %s", r.param), nil
+		}
+	case "oneof":
+		values := strings.Fields(r.param)
+		actual := fmt.Sprintf("%v", v.Interface())
+		for _, value := range values {
+			if actual == value {
+				return "", nil
+			}
+		}
+		return fmt.Sprintf(
+			"must be one of [%s]", strings.Join(values, ", "),
+		), nil
+	case "email":
+		s, err := stringValue(v, r)
+		if err != nil {
+			return "", err
+		}
+		addr, err := mail.ParseAddress(s)
+		if err != nil || addr.Address != s {
+			return "must be a valid email address", nil
+		}
+	case "uuid":
+		s, err := stringValue(v, r)
+		if err != nil {
+			return "", err
+		}
+		if !uuidPattern.MatchString(s) {
+			return "must be a valid UUID", nil
+		}
+	default:
+		return "", fmt.Errorf("unknown validation rule %q", r.name)
+	}
+	return "", nil
+}
+
+// checkBound applies the min, max or len rule to the value.
+func checkBound(v reflect.Value, r rule) (string, error) {
+	bound, err := strconv.ParseFloat(r.param, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s parameter %q", r.name, r.param)
+	}
+	var actual float64
+	subject := "must be"
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		actual = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		actual = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		actual = v.Float()
+	case reflect.String:
+		actual = float64(utf8.RuneCountInString(v.String()))
+		subject = "length must be"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		actual = float64(v.Len())
+		subject = "length must be"
+	default:
+		return "", fmt.Errorf("%s is not supported for %s", r.name, v.Kind())
+	}
+	switch {
+	case r.name == "min" && actual < bound:
+		return fmt.Sprintf("%s at least %s", subject, r.param), nil
+	case r.name == "max" && actual > bound:
+		return fmt.Sprintf("%s at most %s", subject, r.param), nil
+	case r.name == "len" && actual != bound:
+		if subject == "must be" {
+			return "", fmt.Errorf("len is not supported for %s", v.Kind())
+		}
+		return fmt.Sprintf("%s %s", subject, r.param), nil
+	}
+	return "", nil
+}
+
+// stringValue returns the value as a string if it is one.
+func stringValue(v reflect.Value, r rule) (string, error) {
+	if v.Kind() != reflect.String {
+		return "", fmt.Errorf("%s is not supported for %s", r.name, v.Kind())
+	}
+	return v.String(), nil
+}
+
+// compilePattern returns the compiled regular expression of a regexp rule.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regexp %q; %s", pattern, err.Error())
+	}
+	patterns.Store(pattern, re)
+	return re, nil
+}
+
+// indirect dereferences pointers and interfaces until a concrete value is
+// reached. Nil pointers and interfaces result in an invalid value.
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// isEmpty returns true if the value is nil, or the zero value of its type, or
+// an empty string, slice or map.
+func isEmpty(v reflect.Value) bool {
+	v = indirect(v)
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// hasNested returns true if values of the type may contain validated fields.
+func hasNested(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Interface, reflect.Slice, reflect.Array,
+		reflect.Map:
+		return true
+	}
+	return false
+}
+
+// fieldName returns the name of a struct field as it appears in JSON.
+func fieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return f.Name
+	}
+	return name
+}
+
+// joinFieldPath joins a field name to the path of its parent.
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type validateTestItem struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type validateTestUser struct {
+	Name     string             `json:"name" validate:"required,min=2,max=8"`
+	Age      int                `json:"age" validate:"min=18"`
+	Code     string             `json:"code" validate:"omitempty,len=3"`
+	Handle   string             `json:"handle" validate:"regexp=^[a-z]{1,3}$"`
+	Role     string             `json:"role" validate:"oneof=admin user"`
+	Email    string             `json:"email" validate:"email"`
+	Id       string             `json:"id" validate:"uuid"`
+	Tags     []string           `json:"tags" validate:"max=2,dive,min=2"`
+	Limits   map[string]int     `json:"limits" validate:"dive,max=10"`
+	Items    []validateTestItem `json:"items"`
+	Manager  *validateTestItem  `json:"manager"`
+	Internal string             `validate:"-"`
+}
+
+func validTestUser() validateTestUser {
+	return validateTestUser{
+		Name:    "alice",
+		Age:     30,
+		Handle:  "abc",
+		Role:    "admin",
+		Email:   "alice@example.com",
+		Id:      "123e4567-e89b-12d3-a456-426614174000",
+		Tags:    []string{"ab"},
+		Limits:  map[string]int{"a": 1},
+		Items:   []validateTestItem{{Name: "x"}},
+		Manager: &validateTestItem{Name: "bob"},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	user := validTestUser()
+	require.Nil(t, Validate(user))
+	require.Nil(t, Validate(&user))
+
+	user = validateTestUser{
+		Age:     1,
+		Code:    "ab",
+		Handle:  "abcd",
+		Role:    "guest",
+		Email:   "alice",
+		Id:      "abc",
+		Tags:    []string{"a", "bc", "de"},
+		Limits:  map[string]int{"a": 11},
+		Items:   []validateTestItem{{}},
+		Manager: &validateTestItem{},
+	}
+	err := Validate(user)
+	require.NotNil(t, err)
+	errs, ok := err.(ValidationErrors)
+	require.True(t, ok)
+	expected := ValidationErrors{
+		{"name", "required", "is required"},
+		{"age", "min", "must be at least 18"},
+		{"code", "len", "length must be 3"},
+		{"handle", "regexp", "must match pattern ^[a-z]{1,3}$"},
+		{"role", "oneof", "must be one of [admin, user]"},
+		{"email", "email", "must be a valid email address"},
+		{"id", "uuid", "must be a valid UUID"},
+		{"tags", "max", "length must be at most 2"},
+		{"tags[0]", "min", "length must be at least 2"},
+		{"limits[a]", "max", "must be at most 10"},
+		{"items[0].name", "required", "is required"},
+		{"manager.name", "required", "is required"},
+	}
+	require.Equal(t, expected, errs)
+}
+
+func TestValidateInvalidRule(t *testing.T) {
+	v := struct {
+		Name string `validate:"unknown"`
+	}{Name: "alice"}
+	err := Validate(v)
+	require.NotNil(t, err)
+	_, ok := err.(ValidationErrors)
+	require.False(t, ok)
+}
+
+func TestParseRules(t *testing.T) {
+	expected := []rule{
+		{name: "required"},
+		{name: "min", param: "1"},
+		{name: "regexp", param: "^[a-z,]+$"},
+	}
+	require.Equal(t, expected, parseRules("required, min=1,regexp=^[a-z,]+$"))
+	require.Equal(t, []rule{}, parseRules(""))
+}
+
+func TestValidationErrorsResponse(t *testing.T) {
+	errs := ValidationErrors{{"name", "required", "is required"}}
+	require.Equal(t, "name: is required", errs.Error())
+	rr := httptest.NewRecorder()
+	JsonResponse(rr, errs, http.StatusBadRequest)
+	actual := Error{}
+	require.Nil(t, json.Unmarshal(rr.Body.Bytes(), &actual))
+	require.Equal(t, ErrValidationCode, actual.Code)
+	require.Equal(t, []ErrorDetail{
+		{Field: "name", Rule: "required", Message: "is required"},
+	}, actual.Details)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// responseWriter wraps an http.ResponseWriter to record the status and size
+// of the response.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int   // response status code
+	size        int64 // number of body bytes written
+	wroteHeader bool  // indicates whether the header has been written
+}
+
+// newResponseWriter returns a new responseWriter wrapping the given writer.
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+// WriteHeader records and writes the response status code.
+func (w *responseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the number of bytes written to the response body.
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// Flush flushes buffered data to the client if supported by the underlying
+// writer.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection if supported by the
+// underlying writer.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("hijacking is not supported")
+}
+
+// Unwrap returns the underlying response writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Status returns the response status code.
+func (w *responseWriter) Status() int {
+	return w.status
+}
+
+// Size returns the number of body bytes written.
+func (w *responseWriter) Size() int64 {
+	return w.size
+}
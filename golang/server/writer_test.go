@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseWriter(t *testing.T) {
+	rr := httptest.NewRecorder()
+	w := newResponseWriter(rr)
+	require.Equal(t, http.StatusOK, w.Status())
+	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusAccepted)
+	n, err := w.Write([]byte("hello"))
+	require.Nil(t, err)
+	require.Equal(t, 5, n)
+	w.Flush()
+	require.Equal(t, http.StatusCreated, w.Status())
+	require.Equal(t, int64(5), w.Size())
+	require.Equal(t, rr, w.Unwrap())
+	require.True(t, rr.Flushed)
+	_, _, err = w.Hijack()
+	require.NotNil(t, err)
+}
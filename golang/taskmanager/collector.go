@@ -6,6 +6,8 @@ type Collector interface {
 	Start()
 	Stop()
 	Collect(t Task)
+	QueueLength() int
+	QueueCapacity() int
 }
 
 type collector struct {
@@ -27,3 +29,11 @@ func (c *collector) Stop() {
 func (c *collector) Collect(t Task) {
 	c.Dispatcher.Dispatch(NewRequest(t))
 }
+
+func (c *collector) QueueLength() int {
+	return c.Dispatcher.QueueLength()
+}
+
+func (c *collector) QueueCapacity() int {
+	return c.Dispatcher.QueueCapacity()
+}
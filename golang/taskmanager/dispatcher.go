@@ -1,14 +1,19 @@
 package taskmanager
 
-import ()
+import (
+	"sync/atomic"
+)
 
 type Dispatcher interface {
 	Start()
 	Stop()
 	Dispatch(r Request)
+	QueueLength() int
+	QueueCapacity() int
 }
 
 type dispatcher struct {
+	Pending     int64
 	WorkQueue   WorkQueue
 	MaxRequests int
 	WorkerQueue WorkerQueue
@@ -44,9 +49,18 @@ func (d *dispatcher) Stop() {
 }
 
 func (d *dispatcher) Dispatch(r Request) {
+	atomic.AddInt64(&d.Pending, 1)
 	d.WorkQueue <- r
 }
 
+func (d *dispatcher) QueueLength() int {
+	return int(atomic.LoadInt64(&d.Pending))
+}
+
+func (d *dispatcher) QueueCapacity() int {
+	return d.MaxRequests
+}
+
 func (d *dispatcher) process() {
 	for {
 		select {
@@ -61,4 +75,5 @@ func (d *dispatcher) process() {
 func (d *dispatcher) take(r Request) {
 	worker := <-d.WorkerQueue
 	worker <- r
+	atomic.AddInt64(&d.Pending, -1)
 }